@@ -2,32 +2,119 @@ package dog
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
-	"strings"
+	"sort"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/dogtools/dog/run"
 )
 
-// ProvideExtraInfo specifies if dog needs to provide execution info (duration,
-// exit status) after task execution.
-var ProvideExtraInfo bool
+// KillGracePeriod is the time a runner is given to exit after being asked
+// to stop, before it gets killed.
+var KillGracePeriod = 5 * time.Second
+
+// outputDrainTimeout is the time spent reading the output of a task after
+// it exits, while other processes it started keep the output open.
+const outputDrainTimeout = time.Second
+
+// TimeoutError means that a task was stopped because it reached its timeout.
+type TimeoutError struct {
+	Task    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Task %q timed out after %s", e.Task, e.Timeout)
+}
+
+// SignalError is the cause of the cancellation of a task chain execution
+// interrupted by a signal, as set with context.WithCancelCause. Tasks
+// running when the context is cancelled receive the same signal instead
+// of SIGTERM.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("Signal %s received", e.Signal)
+}
+
+// Is makes a SignalError match context.Canceled.
+func (e *SignalError) Is(target error) bool {
+	return target == context.Canceled
+}
 
 // ErrCycleInTaskChain means that there is a loop in the path of tasks execution.
+//
+// Errors returned when a cycle is found are of type *CycleError, which
+// includes the tasks forming the loop and matches this error with errors.Is.
 var ErrCycleInTaskChain = errors.New("TaskChain includes a cycle of tasks")
 
 // TaskChain contains one or more tasks to be executed in order.
 type TaskChain struct {
 	Tasks []Task
+
+	// Params holds the values provided at runtime for the parameters of
+	// the tasks in the chain, mapped by parameter name.
+	Params map[string]string
+
+	// Observers receive the events generated while the chain runs.
+	Observers []Observer
+
+	// Jobs is the maximum number of tasks that can run at the same time.
+	// Tasks only run concurrently when they don't depend on each other,
+	// as the pre-hooks of a task marked as parallel. Values lower than
+	// one mean one task at a time.
+	Jobs int
+
+	// ShowRegistered writes the output stored in registers to the output
+	// of the chain too, as if every task had RegisterTee set.
+	ShowRegistered bool
+
+	// Kill, when closed, kills the tasks being stopped right away instead
+	// of giving them KillGracePeriod to exit, as when a second interrupt
+	// signal is received.
+	Kill <-chan struct{}
+
+	// Background keeps the tasks in the background of the terminal, so
+	// signals sent from the terminal, like Ctrl-C, reach the program
+	// running the chain instead of a single task. It must be set when
+	// other task chains run at the same time. Tasks running in the
+	// background can't read from the terminal. Chains with more than one
+	// job always run their tasks in the background.
+	Background bool
+
+	// Collapsed contains the names of the tasks that were referenced more
+	// than once while generating the chain but only appear once in it.
+	Collapsed []string
+
+	// deps holds, for each task in the chain, the positions of the tasks
+	// it depends on.
+	deps [][]int
+
+	// exits maps the name of each task in the chain to the positions of
+	// the tasks that end its part of the chain, including its post-hooks.
+	exits map[string][]int
 }
 
 // NewTaskChain creates the task chain for a specific dogfile and task.
 func NewTaskChain(dtasks Dogtasks, task string) (taskChain TaskChain, err error) {
-	err = taskChain.generate(dtasks, task)
+	err = dtasks.loadReferences(task)
+	if err != nil {
+		return
+	}
+	err = dtasks.checkReferences([]string{task})
+	if err != nil {
+		return
+	}
+	_, err = taskChain.generate(dtasks, task, nil)
 	if err != nil {
 		return
 	}
@@ -35,126 +122,571 @@ func NewTaskChain(dtasks Dogtasks, task string) (taskChain TaskChain, err error)
 }
 
 // Generate recursively iterates over all tasks, including pre and post tasks for
-// each of them, and adds all of them into a task chain.
-func (taskChain *TaskChain) generate(dtasks Dogtasks, task string) error {
+// each of them, and adds all of them into a task chain. The tasks must not
+// form a cycle.
+//
+// The first tasks added depend on the chain positions in deps. The positions
+// of the tasks that end the added part of the chain are returned, so the
+// tasks that come next can depend on them.
+func (taskChain *TaskChain) generate(dtasks Dogtasks, task string, deps []int) ([]int, error) {
 
 	t, found := dtasks.Tasks[task]
 	if !found {
-		return fmt.Errorf("Task %q does not exist", task)
+		return nil, fmt.Errorf("Task %q does not exist", task)
 	}
 
-	// Cycle detection
-	for i := 0; i < len(taskChain.Tasks); i++ {
-		if taskChain.Tasks[i].Name == task {
-			if len(taskChain.Tasks[i].Pre) > 0 || len(taskChain.Tasks[i].Post) > 0 {
-				return ErrCycleInTaskChain
-			}
-		}
+	// Tasks run at most once, so the ones already in the chain are not
+	// added again unless they must always run. Tasks coming next depend
+	// on both the previous task and the existing part of the chain.
+	if exits, ok := taskChain.exits[task]; ok && !t.Always {
+		taskChain.collapse(task)
+		return mergePositions(deps, exits), nil
 	}
 
 	// Iterate over pre-tasks
-	if err := addToChain(taskChain, dtasks, t.Pre); err != nil {
-		return err
+	deps, err := addToChain(taskChain, dtasks, t.Pre, deps, t.Parallel)
+	if err != nil {
+		return nil, err
 	}
 
 	// Add current task to chain
 	taskChain.Tasks = append(taskChain.Tasks, *t)
+	taskChain.deps = append(taskChain.deps, deps)
 
 	// Iterate over post-tasks
-	if err := addToChain(taskChain, dtasks, t.Post); err != nil {
-		return err
+	exits, err := addToChain(taskChain, dtasks, t.Post, []int{len(taskChain.Tasks) - 1}, false)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	if _, ok := taskChain.exits[task]; !ok {
+		if taskChain.exits == nil {
+			taskChain.exits = make(map[string][]int)
+		}
+		taskChain.exits[task] = exits
+	}
+	return exits, nil
+}
+
+// collapse records that a task was referenced again but not added.
+func (taskChain *TaskChain) collapse(task string) {
+	for _, name := range taskChain.Collapsed {
+		if name == task {
+			return
+		}
+	}
+	taskChain.Collapsed = append(taskChain.Collapsed, task)
+}
+
+// mergePositions returns the sorted union of two lists of chain positions.
+func mergePositions(a, b []int) []int {
+	seen := make(map[int]bool)
+	var merged []int
+	for _, p := range append(append([]int{}, a...), b...) {
+		if !seen[p] {
+			seen[p] = true
+			merged = append(merged, p)
+		}
+	}
+	sort.Ints(merged)
+	return merged
 }
 
 // addToChain adds found tasks into the task chain.
-func addToChain(taskChain *TaskChain, dtasks Dogtasks, tasks []string) error {
+//
+// Each task depends on the previous one, unless they are parallel. In that
+// case all of them depend on deps and can run at the same time.
+func addToChain(taskChain *TaskChain, dtasks Dogtasks, tasks []string, deps []int, parallel bool) ([]int, error) {
+	if len(tasks) == 0 {
+		return deps, nil
+	}
+
+	var last []int
 	for _, name := range tasks {
 
 		t, found := dtasks.Tasks[name]
 		if !found {
-			return fmt.Errorf("Task %q does not exist", name)
+			return nil, fmt.Errorf("Task %q does not exist", name)
 		}
 
-		if err := taskChain.generate(dtasks, t.Name); err != nil {
-			return err
+		exits, err := taskChain.generate(dtasks, t.Name, deps)
+		if err != nil {
+			return nil, err
+		}
+		if parallel {
+			last = append(last, exits...)
+		} else {
+			deps, last = exits, exits
 		}
 	}
-	return nil
+	return last, nil
+}
+
+// dependencies returns the positions in the chain of the tasks that must
+// finish before the task at position i can start.
+//
+// Task chains that were not generated by NewTaskChain run their tasks one
+// after the other.
+func (taskChain *TaskChain) dependencies(i int) []int {
+	if len(taskChain.deps) == len(taskChain.Tasks) {
+		return taskChain.deps[i]
+	}
+	if i == 0 {
+		return nil
+	}
+	return []int{i - 1}
+}
+
+// ancestors returns the positions in the chain of all the tasks that the
+// task at position i depends on, directly or through other tasks, sorted
+// in chain order.
+func (taskChain *TaskChain) ancestors(i int) []int {
+	seen := make([]bool, len(taskChain.Tasks))
+	pending := append([]int{}, taskChain.dependencies(i)...)
+	for len(pending) > 0 {
+		j := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if !seen[j] {
+			seen[j] = true
+			pending = append(pending, taskChain.dependencies(j)...)
+		}
+	}
+
+	var positions []int
+	for j, ok := range seen {
+		if ok {
+			positions = append(positions, j)
+		}
+	}
+	return positions
 }
 
 // Run handles the execution of all tasks in the TaskChain.
-func (taskChain *TaskChain) Run(stdout, stderr io.Writer) error {
-	var startTime time.Time
-	var registers []string
+func (taskChain *TaskChain) Run(stdout, stderr io.Writer) (*ChainResult, error) {
+	return taskChain.RunContext(context.Background(), stdout, stderr)
+}
 
+// RunContext handles the execution of all tasks in the TaskChain until
+// they finish or the context is done.
+//
+// A task starts once all the tasks it depends on have finished. When a
+// task fails, or the context is done, no more tasks are started and the
+// error is returned after the ones already running finish. Tasks running
+// when the context is done are stopped, using the signal of its cause when
+// it is a *SignalError.
+//
+// The returned result is never nil and contains the outcome of every
+// task in the chain, including the ones skipped after a failure.
+func (taskChain *TaskChain) RunContext(ctx context.Context, stdout, stderr io.Writer) (result *ChainResult, err error) {
+	n := &notifier{observers: taskChain.Observers}
+
+	result = &ChainResult{Start: time.Now()}
+	var names []string
 	for _, t := range taskChain.Tasks {
-		var err error
-		var runner run.Runner
-		register := new(bytes.Buffer)
-
-		exitStatus := 0
-		env := append(t.Env, registers...)
-
-		switch t.Runner {
-		case "sh":
-			runner, err = run.NewShRunner(t.Code, t.Workdir, env)
-		case "bash":
-			runner, err = run.NewBashRunner(t.Code, t.Workdir, env)
-		default:
-			if t.Runner == "" {
-				return errors.New("Runner not specified")
+		result.Tasks = append(result.Tasks, TaskResult{Name: t.Name})
+		names = append(names, t.Name)
+	}
+	n.notify(ChainStarted{Tasks: names, Time: result.Start})
+	defer func() {
+		result.End = time.Now()
+		n.notify(ChainFinished{Result: result, Err: err})
+	}()
+
+	if err := taskChain.CheckParams(); err != nil {
+		return result, err
+	}
+
+	jobs := taskChain.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// tasks running at the same time share the output writers
+	stdout, stderr = &syncWriter{w: stdout}, &syncWriter{w: stderr}
+
+	type finishedTask struct {
+		i   int
+		err error
+	}
+	finished := make(chan finishedTask)
+	started := make([]bool, len(taskChain.Tasks))
+	succeeded := make([]bool, len(taskChain.Tasks))
+	running := 0
+
+	for {
+		for i := range taskChain.Tasks {
+			if running >= jobs || err != nil || ctx.Err() != nil {
+				break
 			}
-			return fmt.Errorf("%s is not a supported runner", t.Runner)
+			if started[i] || !taskChain.ready(i, succeeded) {
+				continue
+			}
+			started[i] = true
+			running++
+			go func(i int) {
+				finished <- finishedTask{i, taskChain.runTask(ctx, i, result, n, stdout, stderr)}
+			}(i)
 		}
-		if err != nil {
-			return err
+
+		if running == 0 {
+			break
 		}
+		f := <-finished
+		running--
+		succeeded[f.i] = f.err == nil
+		if f.err != nil && err == nil {
+			err = f.err
+		}
+	}
 
-		runOut, runErr, err := run.GetOutputs(runner)
-		if err != nil {
-			return err
+	if err == nil && ctx.Err() != nil {
+		for i, t := range taskChain.Tasks {
+			if !started[i] {
+				err = fmt.Errorf("Task %q interrupted: %w", t.Name, context.Cause(ctx))
+				break
+			}
 		}
+	}
+	return result, err
+}
 
-		if t.Register == "" {
-			go io.Copy(stdout, runOut)
-		} else {
-			go io.Copy(register, runOut)
+// ready reports whether all the tasks that the task at position i depends
+// on have succeeded.
+func (taskChain *TaskChain) ready(i int, succeeded []bool) bool {
+	for _, j := range taskChain.dependencies(i) {
+		if !succeeded[j] {
+			return false
 		}
-		go io.Copy(stderr, runErr)
+	}
+	return true
+}
 
-		startTime = time.Now()
-		err = runner.Start()
-		if err != nil {
-			return err
+// runTask executes the task at position i of the chain, recording its
+// outcome in the chain result and notifying the observers.
+//
+// Its environment includes the registers of the tasks it depends on.
+func (taskChain *TaskChain) runTask(ctx context.Context, i int, result *ChainResult, n *notifier, stdout, stderr io.Writer) error {
+	t, res := taskChain.Tasks[i], &result.Tasks[i]
+
+	outRegister, errRegister := new(bytes.Buffer), new(bytes.Buffer)
+
+	args, params, err := resolveParams(t, taskChain.Params)
+	if err != nil {
+		res.State, res.Err = TaskFailed, err
+		return err
+	}
+
+	env, workdir, err := t.runtimeFields(params)
+	if err != nil {
+		res.State, res.Err = TaskFailed, err
+		return err
+	}
+	t.Workdir = workdir
+	for _, j := range taskChain.ancestors(i) {
+		registers := result.Tasks[j].Registers
+		for _, r := range sortedKeys(registers) {
+			env = append(env, fmt.Sprintf("%s=%s", r, registers[r]))
 		}
+	}
+	env = append(env, params...)
 
-		err = runner.Wait()
-		if err != nil {
-			if exitError, ok := err.(*exec.ExitError); ok {
-				if waitStatus, ok := exitError.Sys().(syscall.WaitStatus); !ok {
-					exitStatus = 1 // For unknown error exit codes set it to 1
-				} else {
-					exitStatus = waitStatus.ExitStatus()
-				}
-			}
-			if ProvideExtraInfo {
-				fmt.Printf("-- %s (%s) failed with exit status %d\n",
-					t.Name, time.Since(startTime).String(), exitStatus)
-			}
+	tee := t.RegisterTee || taskChain.ShowRegistered
+	out, errOut := stdout, stderr
+	if t.Register != "" {
+		out = outRegister
+		if tee {
+			out = io.MultiWriter(outRegister, stdout)
+		}
+	}
+	if t.RegisterStderr != "" {
+		errOut = errRegister
+		if tee {
+			errOut = io.MultiWriter(errRegister, stderr)
+		}
+	}
+	outEvents := &outputWriter{notifier: n, task: t.Name, stream: Stdout}
+	errEvents := &outputWriter{notifier: n, task: t.Name, stream: Stderr}
+	if len(n.observers) > 0 {
+		out = io.MultiWriter(out, outEvents)
+		errOut = io.MultiWriter(errOut, errEvents)
+	}
+
+	n.notify(TaskStarted{Task: t.Name, Time: time.Now()})
+	err = taskChain.execute(ctx, t, env, args, out, errOut, res)
+	outEvents.flush()
+	errEvents.flush()
+	if err != nil && t.IgnoreErrors && ignorable(err, res) {
+		res.Err, err = err, nil
+	}
+	if err == nil {
+		err = storeRegisters(t, res, outRegister, errRegister)
+	}
+	if err != nil {
+		res.State, res.Err = TaskFailed, err
+	} else {
+		res.State = TaskSucceeded
+		for _, r := range sortedKeys(res.Registers) {
+			n.notify(TaskRegistered{Task: t.Name, Register: r, Value: res.Registers[r]})
+		}
+	}
+	n.notify(TaskFinished{
+		Task:       t.Name,
+		State:      res.State,
+		ExitStatus: res.ExitStatus,
+		Duration:   res.Duration(),
+		Err:        res.Err,
+	})
+	return err
+}
+
+// ignorable reports whether a task ignoring errors can go on after
+// failing with err: only runners exiting with a non-zero exit status are
+// ignored, but not timeouts and interruptions.
+func ignorable(err error, res *TaskResult) bool {
+	var timeoutErr *TimeoutError
+	switch {
+	case res.ExitStatus == 0:
+		return false
+	case errors.As(err, &timeoutErr):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// sortedKeys returns the keys of a map of registers sorted by name.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// execute runs a single task using its runner, stopping it if it
+// reaches its timeout or the context is done, and records its execution
+// times and exit status in res.
+func (taskChain *TaskChain) execute(ctx context.Context, t Task, env, args []string, stdout, stderr io.Writer, res *TaskResult) (err error) {
+	var copying sync.WaitGroup
+
+	var newRunner run.RunnerFactory
+	if t.Func != nil {
+		newRunner = func(string, string, []string, ...string) (run.Runner, error) {
+			return run.NewFuncRunner(t.Func, env)
+		}
+	} else if t.RunnerSpec != nil {
+		newRunner = t.RunnerSpec.factory()
+	} else {
+		if t.Runner == "" {
+			return errors.New("Runner not specified")
+		}
+		var found bool
+		newRunner, found = run.Lookup(t.Runner)
+		if !found {
+			return fmt.Errorf("%s is not a supported runner", t.Runner)
+		}
+	}
+	runner, err := newRunner(t.Code, t.Workdir, env, args...)
+	if err != nil {
+		return err
+	}
+
+	// a task in the foreground of the terminal would be the only one
+	// getting the signals sent from it
+	if fr, ok := runner.(run.ForegroundRunner); ok && (taskChain.Background || taskChain.Jobs > 1) {
+		fr.KeepBackground()
+	}
+	if t.TTY {
+		tr, ok := runner.(run.TerminalRunner)
+		if !ok {
+			return fmt.Errorf("Task %q can't run in a terminal", t.Name)
+		}
+		if err = tr.UseTerminal(); err != nil {
 			return err
 		}
+	}
+
+	runOut, err := runner.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	runErr, err := runner.StderrPipe()
+	if err != nil {
+		runOut.Close()
+		return err
+	}
 
-		if t.Register != "" {
-			r := fmt.Sprintf("%s=%s", t.Register, register.String())
-			registers = append(registers, strings.TrimSpace(r))
+	out, errOut := &cutWriter{w: stdout}, &cutWriter{w: stderr}
+	copying.Add(2)
+	go func() {
+		defer copying.Done()
+		io.Copy(out, runOut)
+	}()
+	go func() {
+		defer copying.Done()
+		io.Copy(errOut, runErr)
+	}()
+	defer func() {
+		runOut.Close()
+		runErr.Close()
+	}()
+
+	res.Start = time.Now()
+	defer func() {
+		res.End = time.Now()
+	}()
+
+	err = runner.Start()
+	if err != nil {
+		return err
+	}
+
+	taskCtx := ctx
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		taskCtx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	finished, watched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-taskCtx.Done():
+			stop(runner, stopSignal(taskCtx), finished, taskChain.Kill)
+		case <-finished:
 		}
+	}()
+
+	err = runner.Wait()
+	close(finished)
+	<-watched
+
+	// processes left in the background by the task keep its outputs
+	// open, so they are only read for a while once the task exits
+	drained := make(chan struct{})
+	go func() {
+		copying.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(outputDrainTimeout):
+		out.cut()
+		errOut.cut()
+	}
 
-		if ProvideExtraInfo {
-			fmt.Printf("-- %s (%s) finished with exit status %d\n",
-				t.Name, time.Since(startTime).String(), exitStatus)
+	if err != nil {
+		res.ExitStatus = 1 // For unknown error exit codes set it to 1
+		if exitError, ok := err.(*exec.ExitError); ok {
+			if waitStatus, ok := exitError.Sys().(syscall.WaitStatus); ok {
+				res.ExitStatus = waitStatus.ExitStatus()
+				if waitStatus.Signaled() {
+					res.Signal = waitStatus.Signal()
+				}
+			}
 		}
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Task %q interrupted: %w", t.Name, context.Cause(ctx))
+	}
+	if taskCtx.Err() != nil {
+		return &TimeoutError{Task: t.Name, Timeout: t.Timeout}
+	}
+	return err
+}
 
+// factory returns a function that creates runners following the spec.
+func (spec *RunnerSpec) factory() run.RunnerFactory {
+	return func(code string, workdir string, env []string, args ...string) (run.Runner, error) {
+		env = append(append([]string{}, spec.Env...), env...)
+		return run.NewCommandRunner(spec.Command, spec.Extension, code, workdir, env, args...)
+	}
+}
+
+// cutWriter forwards writes to w until it is cut, and discards them
+// afterwards.
+type cutWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	off bool
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.off {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
+
+// cut stops forwarding writes to w.
+func (w *cutWriter) cut() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.off = true
+}
+
+// syncWriter serializes the writes of tasks running at the same time.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// stop asks a runner to terminate sending it sig and kills it if it is still
+// running after KillGracePeriod, or as soon as kill is closed. The runner is
+// considered finished once stopped is closed.
+func stop(runner run.Runner, sig os.Signal, stopped, kill <-chan struct{}) {
+	_ = runner.Signal(sig)
+	select {
+	case <-time.After(KillGracePeriod):
+		_ = runner.Signal(os.Kill)
+	case <-kill:
+		_ = runner.Signal(os.Kill)
+	case <-stopped:
+	}
+}
+
+// stopSignal returns the signal used to stop the runners of a done context:
+// the signal that interrupted the execution, if any, or SIGTERM.
+func stopSignal(ctx context.Context) os.Signal {
+	var sigErr *SignalError
+	if errors.As(context.Cause(ctx), &sigErr) {
+		return sigErr.Signal
+	}
+	return syscall.SIGTERM
+}
+
+// CheckParams makes sure that every parameter value provided at runtime
+// belongs to a task in the chain and that all tasks get valid values for
+// their parameters.
+func (taskChain *TaskChain) CheckParams() error {
+	for name := range taskChain.Params {
+		found := false
+		for _, t := range taskChain.Tasks {
+			for _, p := range t.Params {
+				if p.Name == name {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("Unknown parameter %s", name)
+		}
+	}
+	for _, t := range taskChain.Tasks {
+		if _, _, err := resolveParams(t, taskChain.Params); err != nil {
+			return err
+		}
 	}
 	return nil
 }
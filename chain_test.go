@@ -2,8 +2,19 @@ package dog
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/dogtools/dog/run"
 )
 
 func TestCycleDetection(t *testing.T) {
@@ -47,7 +58,7 @@ func TestRunTaskChain(t *testing.T) {
 	}
 
 	runOut, runErr := new(bytes.Buffer), new(bytes.Buffer)
-	if err = taskChain.Run(runOut, runErr); err != nil {
+	if _, err = taskChain.Run(runOut, runErr); err != nil {
 		t.Fatalf("Failed running a task chain: %v", err)
 	}
 
@@ -73,7 +84,7 @@ func TestRunTaskChainMustFail(t *testing.T) {
 		t.Fatalf("Failed generating a task chain: %v", err)
 	}
 
-	if err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
+	if _, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
 		t.Fatalf("Failed to detect a non-zero status code")
 	}
 }
@@ -141,7 +152,7 @@ func TestRunTaskChainNoRunner(t *testing.T) {
 		t.Fatalf("Failed generating a task chain: %v", err)
 	}
 
-	if err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
+	if _, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
 		t.Fatalf("Failed to detect a task without runner")
 	}
 }
@@ -163,7 +174,1108 @@ func TestRunTaskChainUnsupportedRunner(t *testing.T) {
 		t.Fatalf("Failed generating a task chain: %v", err)
 	}
 
-	if err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
+	if _, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err == nil {
 		t.Fatalf("Failed to detect an unsupported runner: %v", err)
 	}
 }
+
+func TestRunTaskChainParams(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"foo": {
+				Name:   "foo",
+				Runner: "sh",
+				Code:   `echo "$1 $2 $animal"`,
+				Params: []Param{
+					{Name: "animal", Required: true, Choices: []string{"dog", "cat"}},
+					{Name: "age", Default: "7", Regex: `^\d+$`},
+				},
+			},
+		},
+	}
+
+	for i, test := range []struct {
+		params map[string]string
+		output string
+		fail   bool
+	}{
+		{map[string]string{"animal": "dog"}, "dog 7 dog", false},
+		{map[string]string{"animal": "cat", "age": "3"}, "cat 3 cat", false},
+		{map[string]string{}, "", true},
+		{map[string]string{"animal": "bird"}, "", true},
+		{map[string]string{"animal": "dog", "age": "old"}, "", true},
+		{map[string]string{"animal": "dog", "city": "Barcelona"}, "", true},
+	} {
+		taskChain, err := NewTaskChain(dtasks, "foo")
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+		taskChain.Params = test.params
+
+		runOut := new(bytes.Buffer)
+		_, err = taskChain.Run(runOut, new(bytes.Buffer))
+		if test.fail {
+			if err == nil {
+				t.Errorf("Test %d: expected an error for params %v", i, test.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: failed running a task chain: %v", i, err)
+		}
+		if got, want := strings.TrimSpace(runOut.String()), test.output; got != want {
+			t.Errorf("Test %d: expected %v but was %v", i, want, got)
+		}
+	}
+}
+
+func TestRunTaskChainBackgroundProcess(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: |
+    echo foo
+    (sleep 5; echo late) &
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	start := time.Now()
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected dog not to wait for background processes but it took %s", elapsed)
+	}
+	if got := runOut.String(); got != "foo\n" {
+		t.Errorf("Expected %q but was %q", "foo\n", got)
+	}
+}
+
+func TestRunTaskChainTimeout(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"slow": {
+				Name:    "slow",
+				Runner:  "sh",
+				Code:    "sleep 10",
+				Timeout: 100 * time.Millisecond,
+			},
+			"stubborn": {
+				Name:    "stubborn",
+				Runner:  "sh",
+				Code:    "trap '' TERM; sleep 10",
+				Timeout: 100 * time.Millisecond,
+			},
+		},
+	}
+
+	defer func(grace time.Duration) { KillGracePeriod = grace }(KillGracePeriod)
+	KillGracePeriod = 100 * time.Millisecond
+
+	for _, name := range []string{"slow", "stubborn"} {
+		taskChain, err := NewTaskChain(dtasks, name)
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+
+		start := time.Now()
+		_, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+		if _, ok := err.(*TimeoutError); !ok {
+			t.Errorf("Expected a timeout error for task %s but was %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Task %s was not stopped after its timeout (%s)", name, elapsed)
+		}
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"slow": {
+				Name:   "slow",
+				Runner: "sh",
+				Code:   "sleep 10",
+				Post:   []string{"after"},
+			},
+			"after": {
+				Name:   "after",
+				Runner: "sh",
+				Code:   "echo after",
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "slow")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	runOut := new(bytes.Buffer)
+	start := time.Now()
+	_, err = taskChain.RunContext(ctx, runOut, new(bytes.Buffer))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a context error but was %v", err)
+	}
+	if !strings.Contains(err.Error(), `"slow"`) {
+		t.Errorf("Expected the error to include the task name but was %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Task was not stopped after cancelling the context (%s)", elapsed)
+	}
+	if runOut.Len() > 0 {
+		t.Errorf("Expected the rest of the chain to be skipped but got %q", runOut.String())
+	}
+}
+
+func TestRunTaskChainResult(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"get-animal": {
+				Name:     "get-animal",
+				Runner:   "sh",
+				Code:     "echo dog",
+				Register: "ANIMAL",
+			},
+			"main": {
+				Name:   "main",
+				Runner: "sh",
+				Pre:    []string{"get-animal"},
+				Post:   []string{"never"},
+				Code:   "exit 3",
+			},
+			"never": {
+				Name:   "never",
+				Runner: "sh",
+				Code:   "echo never",
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "main")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	result, err := taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+	if err == nil {
+		t.Fatalf("Failed to detect a non-zero status code")
+	}
+
+	want := []struct {
+		name       string
+		state      TaskState
+		exitStatus int
+		register   string
+	}{
+		{"get-animal", TaskSucceeded, 0, "dog"},
+		{"main", TaskFailed, 3, ""},
+		{"never", TaskSkipped, 0, ""},
+	}
+	if len(result.Tasks) != len(want) {
+		t.Fatalf("Expected %d task results but was %d", len(want), len(result.Tasks))
+	}
+	for i, w := range want {
+		got := result.Tasks[i]
+		if got.Name != w.name || got.State != w.state ||
+			got.ExitStatus != w.exitStatus || got.Register != w.register {
+			t.Errorf("Task %d: expected %+v but was %+v", i, w, got)
+		}
+	}
+	if failed := result.Failed(); failed == nil || failed.Name != "main" {
+		t.Errorf("Expected main to be the failed task but was %v", failed)
+	}
+	if result.Duration() < result.Tasks[1].Duration() {
+		t.Errorf("Chain duration %s is shorter than task duration %s",
+			result.Duration(), result.Tasks[1].Duration())
+	}
+}
+
+func TestRunTaskChainParallel(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"main": {
+				Name:     "main",
+				Runner:   "sh",
+				Parallel: true,
+				Pre:      []string{"one", "two"},
+				Code:     `echo "main $ONE"`,
+			},
+			"one": {
+				Name:     "one",
+				Runner:   "sh",
+				Code:     "sleep 0.5; echo 1",
+				Register: "ONE",
+			},
+			"two": {
+				Name:   "two",
+				Runner: "sh",
+				Code:   `sleep 0.5; echo "two sees '$ONE'"`,
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "main")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	taskChain.Jobs = 2
+
+	runOut := new(bytes.Buffer)
+	start := time.Now()
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Parallel pre-hooks didn't run at the same time (%s)", elapsed)
+	}
+
+	want := "two sees ''\nmain 1\n"
+	if got := runOut.String(); got != want {
+		t.Errorf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestTaskChainDependencies(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"main":  {Name: "main", Parallel: true, Pre: []string{"a", "b"}, Post: []string{"c"}},
+			"a":     {Name: "a", Pre: []string{"a-pre"}},
+			"a-pre": {Name: "a-pre"},
+			"b":     {Name: "b"},
+			"c":     {Name: "c"},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "main")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	// chain order: a-pre, a, b, main, c
+	want := [][]int{nil, {0}, nil, {1, 2}, {3}}
+	for i, w := range want {
+		if got := taskChain.dependencies(i); !reflect.DeepEqual(got, w) {
+			t.Errorf("Task %s: expected dependencies %v but was %v",
+				taskChain.Tasks[i].Name, w, got)
+		}
+	}
+}
+
+func TestTaskChainDeduplication(t *testing.T) {
+	for _, always := range []bool{false, true} {
+		dtasks := Dogtasks{
+			Tasks: map[string]*Task{
+				"all":   {Name: "all", Pre: []string{"build", "test"}},
+				"build": {Name: "build", Pre: []string{"deps"}},
+				"test":  {Name: "test", Pre: []string{"deps"}},
+				"deps":  {Name: "deps", Always: always},
+			},
+		}
+
+		taskChain, err := NewTaskChain(dtasks, "all")
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+
+		want, collapsed := []string{"deps", "build", "test", "all"}, []string{"deps"}
+		if always {
+			want, collapsed = []string{"deps", "build", "deps", "test", "all"}, nil
+		}
+		got := []string{}
+		for _, t := range taskChain.Tasks {
+			got = append(got, t.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Always %v: expected chain %v but was %v", always, want, got)
+		}
+		if !reflect.DeepEqual(taskChain.Collapsed, collapsed) {
+			t.Errorf("Always %v: expected collapsed %v but was %v",
+				always, collapsed, taskChain.Collapsed)
+		}
+	}
+}
+
+func TestTaskChainDeduplicationParallel(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"all":   {Name: "all", Parallel: true, Pre: []string{"build", "test"}},
+			"build": {Name: "build", Pre: []string{"deps"}},
+			"test":  {Name: "test", Pre: []string{"deps"}},
+			"deps":  {Name: "deps"},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "all")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	// test must still wait for deps, even if it was added by build
+	if got, want := taskChain.dependencies(2), []int{0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected test dependencies %v but was %v", want, got)
+	}
+}
+
+func TestCycleDetectionPath(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"a": {Name: "a", Pre: []string{"b"}},
+			"b": {Name: "b", Post: []string{"c"}},
+			"c": {Name: "c", Pre: []string{"a"}},
+		},
+	}
+
+	_, err := NewTaskChain(dtasks, "a")
+	cycleErr, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("Expected a cycle error but was %v", err)
+	}
+	if got, want := cycleErr.Path, []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected cycle %v but was %v", want, got)
+	}
+	if !errors.Is(err, ErrCycleInTaskChain) {
+		t.Errorf("Expected the cycle error to match ErrCycleInTaskChain")
+	}
+	if got, want := err.Error(), "TaskChain includes a cycle of tasks: a -> b -> c -> a"; got != want {
+		t.Errorf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestCycleDetectionSharedHooks(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"all":   {Name: "all", Pre: []string{"build", "test"}},
+			"build": {Name: "build", Pre: []string{"deps"}},
+			"test":  {Name: "test", Pre: []string{"deps"}},
+			"deps":  {Name: "deps", Pre: []string{"fetch"}, Always: true},
+			"fetch": {Name: "fetch"},
+		},
+	}
+
+	if err := dtasks.Validate(); err != nil {
+		t.Errorf("Shared hooks were reported as a cycle: %v", err)
+	}
+}
+
+func TestRunTaskChainRegisteredRunner(t *testing.T) {
+	run.Register("shout", func(code string, workdir string, env []string, args ...string) (run.Runner, error) {
+		return run.NewShRunner(code+" | tr a-z A-Z", workdir, env, args...)
+	})
+	t.Cleanup(func() { run.Unregister("shout") })
+
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"foo": {
+				Name:   "foo",
+				Runner: "shout",
+				Code:   "echo foo",
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := strings.TrimSpace(runOut.String()), "FOO"; got != want {
+		t.Fatalf("Expected %v but was %v", want, got)
+	}
+}
+
+func TestRunTaskChainRunnerSpec(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"foo": {
+				Name: "foo",
+				RunnerSpec: &RunnerSpec{
+					Command:   []string{"sh", "-e"},
+					Extension: ".sh",
+					Env:       []string{"GREETING=hello", "ANIMAL=cat"},
+				},
+				Env:  []string{"ANIMAL=dog"},
+				Code: `echo "$GREETING $ANIMAL"`,
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := strings.TrimSpace(runOut.String()), "hello dog"; got != want {
+		t.Fatalf("Expected %v but was %v", want, got)
+	}
+}
+
+func TestRunTaskChainFunc(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  env: ANIMAL=dog
+  code: echo foo
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	task, err := dtasks.AddFunc("bar", func(ctx context.Context, env []string, stdout, stderr io.Writer) error {
+		fmt.Fprintf(stdout, "bar %s\n", strings.Join(env, " "))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed adding a function: %v", err)
+	}
+	task.Pre = []string{"foo"}
+	task.Env = []string{"ANIMAL=cat"}
+
+	if _, err = dtasks.AddFunc("bar", func(context.Context, []string, io.Writer, io.Writer) error {
+		return nil
+	}); err == nil {
+		t.Fatal("Expected an error for a duplicated task")
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := runOut.String(), "foo\nbar ANIMAL=cat\n"; got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestRunTaskChainFuncError(t *testing.T) {
+	dtasks := Dogtasks{}
+	funcErr := errors.New("migration failed")
+	if _, err := dtasks.AddFunc("foo", func(context.Context, []string, io.Writer, io.Writer) error {
+		return funcErr
+	}); err != nil {
+		t.Fatalf("Failed adding a function: %v", err)
+	}
+	if _, err := dtasks.AddFunc("bar", func(ctx context.Context, env []string, stdout, stderr io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}); err != nil {
+		t.Fatalf("Failed adding a function: %v", err)
+	}
+	dtasks.Tasks["bar"].Timeout = 50 * time.Millisecond
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	result, err := taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+	if !errors.Is(err, funcErr) {
+		t.Fatalf("Expected %v but was %v", funcErr, err)
+	}
+	if got := result.Tasks[0].ExitStatus; got != 1 {
+		t.Fatalf("Expected exit status 1 but was %d", got)
+	}
+
+	taskChain, err = NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	_, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected a timeout error but was %v", err)
+	}
+}
+
+func TestRunTaskChainFuncIgnoringContext(t *testing.T) {
+	defer func(grace time.Duration) { KillGracePeriod = grace }(KillGracePeriod)
+	KillGracePeriod = 100 * time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+
+	dtasks := Dogtasks{}
+	task, err := dtasks.AddFunc("foo", func(context.Context, []string, io.Writer, io.Writer) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed adding a function: %v", err)
+	}
+	task.Timeout = 50 * time.Millisecond
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Errorf("Expected a timeout error but was %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Task chain still running after the function was killed")
+	}
+}
+
+func TestRunTaskChainTTY(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  tty: true
+  register: FOO
+  code: |
+    [ -t 0 ] && [ -t 1 ] && [ -t 2 ] || exit 1
+    printf '\033[1mfoo\033[0m\n'
+    echo bar >&2
+
+- task: bar
+  pre: foo
+  code: echo "$FOO"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := runOut.String(), "foo\nbar\n"; got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestRunTaskChainSignal(t *testing.T) {
+	defer func(grace time.Duration) { KillGracePeriod = grace }(KillGracePeriod)
+	KillGracePeriod = 500 * time.Millisecond
+
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: |
+    trap 'echo trapped; exit 3' INT
+    sleep 10 &
+    echo started
+    wait
+
+- task: bar
+  pre: foo
+  code: echo bar
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	taskChain.Observers = []Observer{ObserverFunc(func(e Event) {
+		if o, ok := e.(TaskOutput); ok && o.Line == "started" {
+			cancel(&SignalError{Signal: syscall.SIGINT})
+		}
+	})}
+
+	runOut := new(bytes.Buffer)
+	result, err := taskChain.RunContext(ctx, runOut, new(bytes.Buffer))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected an interrupted task chain but was %v", err)
+	}
+	var sigErr *SignalError
+	if !errors.As(err, &sigErr) || sigErr.Signal != syscall.SIGINT {
+		t.Fatalf("Expected an interruption by SIGINT but was %v", err)
+	}
+	if got, want := runOut.String(), "started\ntrapped\n"; got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+	if got := result.Tasks[0].ExitStatus; got != 3 {
+		t.Fatalf("Expected exit status 3 but was %d", got)
+	}
+	// the background sleep ignores SIGINT and is killed after the
+	// grace period, as the task waits for its whole process group
+	if d := result.Tasks[0].Duration(); d < KillGracePeriod {
+		t.Fatalf("Expected task foo to wait for its process group but it took %s", d)
+	}
+	if got := result.Tasks[1].State; got != TaskSkipped {
+		t.Fatalf("Expected task bar to be skipped but was %v", got)
+	}
+}
+
+func TestRunTaskChainKill(t *testing.T) {
+	defer func(grace time.Duration) { KillGracePeriod = grace }(KillGracePeriod)
+	KillGracePeriod = 10 * time.Second
+
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: |
+    trap '' TERM
+    echo started
+    sleep 10
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	kill := make(chan struct{})
+	taskChain.Kill = kill
+	taskChain.Observers = []Observer{ObserverFunc(func(e Event) {
+		if o, ok := e.(TaskOutput); ok && o.Line == "started" {
+			cancel(&SignalError{Signal: syscall.SIGTERM})
+			time.AfterFunc(100*time.Millisecond, func() { close(kill) })
+		}
+	})}
+
+	result, err := taskChain.RunContext(ctx, new(bytes.Buffer), new(bytes.Buffer))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected an interrupted task chain but was %v", err)
+	}
+	if got := result.Tasks[0].Signal; got != syscall.SIGKILL {
+		t.Fatalf("Expected task foo to be killed but was %v", got)
+	}
+	if d := result.Tasks[0].Duration(); d >= KillGracePeriod {
+		t.Fatalf("Expected task foo to be killed before the grace period but it took %s", d)
+	}
+}
+
+func TestRunTaskChainWorkdirParams(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"staging", "production"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDogfile(t, filepath.Join(dir, "dog.yml"), `
+- task: where
+  params:
+    - name: env
+      default: staging
+  workdir: "{{ .Params.env }}"
+  code: pwd
+- task: other
+  code: echo other
+`)
+
+	dtasks, err := ParseFromDisk(dir)
+	if err != nil {
+		t.Fatalf("Failed parsing a workdir using params: %v", err)
+	}
+	for _, test := range []struct {
+		params map[string]string
+		want   string
+	}{
+		{nil, "staging"},
+		{map[string]string{"env": "production"}, "production"},
+	} {
+		taskChain, err := NewTaskChain(dtasks, "where")
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+		taskChain.Params = test.params
+		runOut := new(bytes.Buffer)
+		if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+			t.Fatalf("Failed running a task chain: %v", err)
+		}
+		got, err := filepath.EvalSymlinks(strings.TrimSpace(runOut.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := filepath.EvalSymlinks(filepath.Join(dir, test.want))
+		if got != want {
+			t.Errorf("Expected the task to run in %s but it ran in %s", want, got)
+		}
+	}
+}
+
+func TestRunTaskChainTempDir(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: echo foo
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	if _, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Fatalf("Expected no temporary files but found %s", entries[0].Name())
+	}
+}
+
+func TestRunTaskChainRegisters(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: |
+    echo out
+    echo err >&2
+    exit 3
+  register: FOO_OUT
+  register_stderr: FOO_ERR
+  register_status: FOO_STATUS
+  ignore_errors: true
+
+- task: bar
+  pre: foo
+  code: echo "$FOO_OUT $FOO_ERR $FOO_STATUS"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut, runErr := new(bytes.Buffer), new(bytes.Buffer)
+	result, err := taskChain.Run(runOut, runErr)
+	if err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := runOut.String(), "out err 3\n"; got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+	if runErr.Len() > 0 {
+		t.Fatalf("Expected no output in stderr but was %q", runErr.String())
+	}
+	if res := result.Tasks[0]; res.State != TaskSucceeded || res.ExitStatus != 3 || res.Err == nil {
+		t.Fatalf("Unexpected result for a task ignoring errors: %+v", res)
+	}
+}
+
+func TestRunTaskChainRegisterTee(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: echo foo; echo oops >&2
+  register: FOO
+  register_stderr: FOO_ERR
+
+- task: bar
+  pre: foo
+  code: echo bar; echo fail >&2
+  register: BAR
+  register_stderr: BAR_ERR
+  register_tee: true
+
+- task: baz
+  pre: bar
+  code: echo "$FOO $FOO_ERR $BAR $BAR_ERR"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	for _, test := range []struct {
+		showRegistered bool
+		stdout         string
+		stderr         string
+	}{
+		{false, "bar\nfoo oops bar fail\n", "fail\n"},
+		{true, "foo\nbar\nfoo oops bar fail\n", "oops\nfail\n"},
+	} {
+		taskChain, err := NewTaskChain(dtasks, "baz")
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+		taskChain.ShowRegistered = test.showRegistered
+
+		runOut, runErr := new(bytes.Buffer), new(bytes.Buffer)
+		if _, err = taskChain.Run(runOut, runErr); err != nil {
+			t.Fatalf("Failed running a task chain: %v", err)
+		}
+		if got := runOut.String(); got != test.stdout {
+			t.Errorf("Expected %q in stdout but was %q", test.stdout, got)
+		}
+		if got := runErr.String(); got != test.stderr {
+			t.Errorf("Expected %q in stderr but was %q", test.stderr, got)
+		}
+	}
+}
+
+func TestRunTaskChainJSONRegister(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: release
+  code: |
+    echo '{"name": "dog", "version": {"major": 1, "minor": 2, "pre-release": null}, "tags": ["a"]}'
+  register: RELEASE
+  register_format: json
+
+- task: version
+  code: |
+    echo '{"versions": [{"major": 1, "minor": 2}]}'
+  register: VERSION
+  register_json: .versions[0]
+
+- task: foo
+  pre: [release, version]
+  code: |
+    echo "$RELEASE_NAME $RELEASE_VERSION $RELEASE_TAGS"
+    echo "$VERSION $VERSION_MAJOR.$VERSION_MINOR"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	want := `dog {"major":1,"minor":2,"pre-release":null} ["a"]
+{"major":1,"minor":2} 1.2
+`
+	if got := runOut.String(); got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestRunTaskChainJSONRegisterErrors(t *testing.T) {
+	for _, code := range []string{
+		`echo '{"version": '`,
+		`echo '{"name": "dog"}'`,
+	} {
+		dtasks := Dogtasks{
+			Tasks: map[string]*Task{
+				"foo": {
+					Name:           "foo",
+					Runner:         "sh",
+					Code:           code,
+					Register:       "FOO",
+					RegisterFormat: RegisterJSON,
+					RegisterPath:   ".version",
+				},
+			},
+		}
+
+		taskChain, err := NewTaskChain(dtasks, "foo")
+		if err != nil {
+			t.Fatalf("Failed generating a task chain: %v", err)
+		}
+		result, err := taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+		if err == nil {
+			t.Errorf("Expected an error registering the output of %s", code)
+		} else if result.Tasks[0].State != TaskFailed {
+			t.Errorf("Expected task foo to fail for %s", code)
+		}
+	}
+}
+
+func TestRunTaskChainEnvPrecedence(t *testing.T) {
+	t.Setenv("DOG_SYSTEM", "system")
+
+	dtasks, err := Parse([]byte(`
+vars:
+  DOG_VAR: var
+  DOG_ENV: var
+  DOG_TASK: var
+  DOG_SYSTEM: var
+env:
+  - DOG_ENV=env
+  - DOG_TASK=env
+tasks:
+  - task: foo
+    register: DOG_REGISTER
+    code: echo register
+
+  - task: bar
+    pre: foo
+    env: [DOG_TASK=task, DOG_SYSTEM=task, DOG_REGISTER=task, animal=task]
+    params:
+      - name: animal
+    code: echo "$DOG_VAR $DOG_ENV $DOG_TASK $DOG_SYSTEM $DOG_REGISTER $animal"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	taskChain.Params = map[string]string{"animal": "param"}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := runOut.String(), "var env task system register param\n"; got != want {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+}
+
+func TestRunTaskChainEnvTemplates(t *testing.T) {
+	t.Setenv("DOG_SYSTEM", "system")
+
+	dtasks, err := Parse([]byte(`
+vars:
+  SERVICE: api
+env: REGISTRY=example.com
+tasks:
+  - task: foo
+    params:
+      - name: tag
+        default: latest
+    env:
+      - IMAGE={{ .Env.REGISTRY }}/{{ .Vars.SERVICE }}:{{ .Params.tag }}
+      - LEVEL={{ env "DOG_UNSET" | default "info" | upper }}
+      - DOG_SYSTEM={{ .Vars.MISSING }}
+    code: echo "$IMAGE $LEVEL $DOG_SYSTEM"
+
+  - task: bar
+    env: IMAGE={{ .Params.tag }}
+    code: echo "$IMAGE"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "foo")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	taskChain.Params = map[string]string{"tag": "v1"}
+
+	runOut := new(bytes.Buffer)
+	if _, err = taskChain.Run(runOut, new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+	if got, want := runOut.String(), "example.com/api:v1 INFO system\n"; got != want {
+		t.Errorf("Expected %q but was %q", want, got)
+	}
+
+	taskChain, err = NewTaskChain(dtasks, "bar")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	_, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer))
+	if err == nil || !strings.Contains(err.Error(), "Task bar") || !strings.Contains(err.Error(), `"tag"`) {
+		t.Errorf("Expected an error for an undefined parameter but was %v", err)
+	}
+}
+
+func TestNewTaskChainPathReferences(t *testing.T) {
+	root := t.TempDir()
+	writeDogfile(t, filepath.Join(root, "dog-root.yml"), "")
+	writeDogfile(t, filepath.Join(root, "tools", "codegen", "dog.yml"), `
+- task: generate
+  code: echo generate
+`)
+	writeDogfile(t, filepath.Join(root, "lib", "dog.yml"), `
+- task: build
+  pre: [prepare, //tools/codegen:generate]
+  code: echo build
+- task: prepare
+  workdir: sub
+  code: echo prepare
+`)
+	writeDogfile(t, filepath.Join(root, "services", "api", "dog.yml"), `
+- task: test
+  pre: [../../lib:build, //tools/codegen:generate]
+  code: pwd
+- task: loop
+  pre: //lib:build
+  post: //services/api:test
+  code: echo loop
+`)
+
+	dtasks, err := ParseFromDisk(filepath.Join(root, "services", "api"))
+	if err != nil {
+		t.Fatalf("Failed parsing a Dogfile with path references: %v", err)
+	}
+	if len(dtasks.Tasks) != 2 {
+		t.Errorf("Expected Dogfiles in other directories to be parsed lazily but found %d tasks", len(dtasks.Tasks))
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "test")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	lib, codegen := filepath.Join(root, "lib"), filepath.Join(root, "tools", "codegen")
+	var names []string
+	for _, task := range taskChain.Tasks {
+		names = append(names, task.Name)
+	}
+	want := []string{lib + ":prepare", codegen + ":generate", lib + ":build", "test"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected task chain %v but was %v", want, names)
+	}
+	if got, want := taskChain.Tasks[0].Workdir, filepath.Join(lib, "sub"); got != want {
+		t.Errorf("Expected workdir %s but was %s", want, got)
+	}
+	if got := taskChain.Tasks[2].Workdir; got != lib {
+		t.Errorf("Expected workdir %s but was %s", lib, got)
+	}
+	if len(dtasks.Tasks) != 2 {
+		t.Errorf("Expected the parsed tasks not to change but found %d tasks", len(dtasks.Tasks))
+	}
+
+	if _, err = NewTaskChain(dtasks, "loop"); err != nil {
+		t.Errorf("Expected a reference to the own directory to be a local task: %v", err)
+	}
+
+	writeDogfile(t, filepath.Join(root, "services", "web", "dog.yml"), `
+- task: test
+  pre: ../../lib:missing
+  code: echo test
+`)
+	dtasks, err = ParseFromDisk(filepath.Join(root, "services", "web"))
+	if err != nil {
+		t.Fatalf("Failed parsing a Dogfile with path references: %v", err)
+	}
+	if _, err = NewTaskChain(dtasks, "test"); err == nil {
+		t.Errorf("Expected an error for a task that does not exist")
+	}
+}
@@ -1,6 +1,12 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
 
 type userArgs struct {
 	help      bool
@@ -8,6 +14,12 @@ type userArgs struct {
 	version   bool
 	info      bool
 	debug     bool
+	showRegs  bool
+	jobs      int
+	workspace bool
+	projects  int
+	include   []string
+	exclude   []string
 	taskName  string
 	taskArgs  map[string][]string
 }
@@ -17,7 +29,13 @@ var knownFlags = [...]string{
 	"-h", "--help",
 	"-v", "--version",
 	"-d", "--directory",
+	"-j", "--jobs",
 	"--debug",
+	"--show-registered",
+	"-r", "--workspace",
+	"-p", "--projects",
+	"--include",
+	"--exclude",
 }
 
 func printVersion() {
@@ -26,20 +44,44 @@ func printVersion() {
 
 func printHelp() {
 	fmt.Println(`Usage: dog
-       dog [OPTIONS] TASK [ARGS]
+       dog [OPTIONS] TASK [--PARAM VALUE]...
+       dog --workspace [OPTIONS] TASK [--PARAM VALUE]...
        dog [--help] [--version]
 
 Dog is a command line application that executes tasks.
+Task parameters are provided as --name value or --name=value.
+
 Options:
   -i, --info       Print execution info (duration, exit status) after task execution
   -d, --directory  Specify the dogfiles' directory
+  -j, --jobs       Number of independent tasks that can run at the same time
   -h, --help       Print usage information and help
   -v, --version    Print version information
-      --debug      Print debug information before running tasks`)
+      --debug      Print debug information before running tasks
+      --show-registered
+                   Print the output of tasks stored in registers
+
+Workspace options:
+  -r, --workspace  Run the task in every project under the current directory
+                   that defines it, and print a summary of the results
+  -p, --projects   Number of projects that can run at the same time
+      --include    Only run in projects matching a glob, can be repeated
+      --exclude    Skip projects matching a glob, can be repeated
+
+Exit status:
+  When a task fails dog exits with the exit status of that task, or with
+  128 plus the signal number if the task was killed by a signal.
+  Other problems use the following exit status codes:
+   64  Invalid arguments or task parameters
+   65  No Dogfile found or invalid Dogfile
+   66  Unknown task
+   67  Cycle of tasks in the task chain
+   70  Task runner could not be started
+  124  Task timed out`)
 }
 
 func printNoValidDogfile() {
-	fmt.Println(`Error: No valid Dogfile in current directory
+	fmt.Fprintln(os.Stderr, `Error: No valid Dogfile in current directory
 Need help? --> dog --help
 More info  --> https://github.com/dogtools/dog`)
 }
@@ -53,6 +95,10 @@ func parseArgs(args []string) (a userArgs, err error) {
 		version:   false,
 		info:      false,
 		debug:     false,
+		showRegs:  false,
+		jobs:      1,
+		workspace: false,
+		projects:  1,
 		taskName:  "",
 		taskArgs:  map[string][]string{},
 	}
@@ -99,12 +145,64 @@ func parseArgs(args []string) (a userArgs, err error) {
 			}
 		}
 
+		if arg == "--show-registered" {
+			if a.taskName == "" {
+				a.showRegs = true
+			} else {
+				return a, fmt.Errorf("Error: %s is not a valid task argument", arg)
+			}
+		}
+
 		if arg == "--directory" || arg == "-d" {
 			next := i + 1
 			a.directory = args[next]
 			skipArgument = true
 		}
 
+		if (arg == "--jobs" || arg == "-j") && a.taskName == "" {
+			next := i + 1
+			if next >= len(args) {
+				return a, fmt.Errorf("Error: %s requires a number of jobs", arg)
+			}
+			a.jobs, err = strconv.Atoi(args[next])
+			if err != nil || a.jobs < 1 {
+				return a, fmt.Errorf("Error: %s is not a valid number of jobs", args[next])
+			}
+			skipArgument = true
+		}
+
+		if (arg == "--workspace" || arg == "-r") && a.taskName == "" {
+			a.workspace = true
+		}
+
+		if (arg == "--projects" || arg == "-p") && a.taskName == "" {
+			next := i + 1
+			if next >= len(args) {
+				return a, fmt.Errorf("Error: %s requires a number of projects", arg)
+			}
+			a.projects, err = strconv.Atoi(args[next])
+			if err != nil || a.projects < 1 {
+				return a, fmt.Errorf("Error: %s is not a valid number of projects", args[next])
+			}
+			skipArgument = true
+		}
+
+		if (arg == "--include" || arg == "--exclude") && a.taskName == "" {
+			next := i + 1
+			if next >= len(args) {
+				return a, fmt.Errorf("Error: %s requires a glob", arg)
+			}
+			if _, err = filepath.Match(args[next], ""); err != nil {
+				return a, fmt.Errorf("Error: %s is not a valid glob", args[next])
+			}
+			if arg == "--include" {
+				a.include = append(a.include, args[next])
+			} else {
+				a.exclude = append(a.exclude, args[next])
+			}
+			skipArgument = true
+		}
+
 		if a.taskName == "" && string(arg[0]) != "-" {
 			a.taskName = arg
 		} else if a.taskName != "" && string(arg[0]) == "-" {
@@ -129,5 +227,36 @@ func parseArgs(args []string) (a userArgs, err error) {
 		}
 	}
 
+	if !a.workspace && (a.projects != 1 || len(a.include) > 0 || len(a.exclude) > 0) {
+		return a, fmt.Errorf("Error: workspace options require --workspace")
+	}
+	if a.workspace && a.taskName == "" {
+		return a, fmt.Errorf("Error: --workspace requires a task name")
+	}
+
 	return a, nil
 }
+
+// parseParams converts the arguments provided to a task into parameter
+// values, mapped by parameter name.
+func parseParams(taskArgs map[string][]string) (map[string]string, error) {
+	params := map[string]string{}
+	for arg, values := range taskArgs {
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.Index(name, "="); i >= 0 {
+			values = append([]string{name[i+1:]}, values...)
+			name = name[:i]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("Error: %s is not a valid task argument", arg)
+		}
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("Error: parameter %s provided more than once", name)
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("Error: parameter %s expects exactly one value", name)
+		}
+		params[name] = values[0]
+	}
+	return params, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWorkspaceArgs(t *testing.T) {
+	for i, test := range []struct {
+		args     []string
+		projects int
+		include  []string
+		exclude  []string
+		fails    bool
+	}{
+		{[]string{"-r", "build"}, 1, nil, nil, false},
+		{[]string{"-r", "-p", "4", "build"}, 4, nil, nil, false},
+		{[]string{"--workspace", "--projects", "2", "build"}, 2, nil, nil, false},
+		{[]string{"-r", "--include", "api", "--include", "web*", "build"}, 1, []string{"api", "web*"}, nil, false},
+		{[]string{"-r", "--exclude", "legacy-*", "build"}, 1, nil, []string{"legacy-*"}, false},
+		{[]string{"-r", "-p", "0", "build"}, 1, nil, nil, true},
+		{[]string{"-r", "-p", "many", "build"}, 1, nil, nil, true},
+		{[]string{"-r", "-p"}, 1, nil, nil, true},
+		{[]string{"-r", "--include"}, 1, nil, nil, true},
+		{[]string{"-r", "--exclude", "[", "build"}, 1, nil, nil, true},
+		{[]string{"-p", "2", "build"}, 1, nil, nil, true},
+		{[]string{"--include", "api", "build"}, 1, nil, nil, true},
+		{[]string{"-r"}, 1, nil, nil, true},
+	} {
+		a, err := parseArgs(test.args)
+		if test.fails {
+			if err == nil {
+				t.Errorf("Test %d: expected %q to fail", i, test.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: failed parsing %q: %v", i, test.args, err)
+			continue
+		}
+		if !a.workspace || a.taskName != "build" {
+			t.Errorf("Test %d: expected a workspace run of build but was %+v", i, a)
+		}
+		if a.projects != test.projects {
+			t.Errorf("Test %d: expected %d projects but was %d", i, test.projects, a.projects)
+		}
+		if !reflect.DeepEqual(a.include, test.include) || !reflect.DeepEqual(a.exclude, test.exclude) {
+			t.Errorf("Test %d: expected include %q and exclude %q but were %q and %q",
+				i, test.include, test.exclude, a.include, a.exclude)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/dogtools/dog"
+)
+
+// Exit status codes used for problems found by dog itself. When a task
+// fails, dog exits with the exit status of that task instead.
+const (
+	exitUsage       = 64  // invalid command line arguments or task parameters
+	exitDogfile     = 65  // no Dogfile found or Dogfile can't be parsed
+	exitUnknownTask = 66  // the requested task does not exist
+	exitCycle       = 67  // the task chain includes a cycle of tasks
+	exitRunner      = 70  // a task runner could not be started
+	exitTimeout     = 124 // a task reached its timeout
+	exitSignal      = 128 // added to the number of the signal that killed a task
+)
+
+// fail prints an error to stderr and exits with the given status.
+func fail(status int, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(status)
+}
+
+// dogfileExitStatus returns the exit status for an error found while
+// parsing a Dogfile or generating a task chain.
+func dogfileExitStatus(err error) int {
+	if errors.Is(err, dog.ErrCycleInTaskChain) {
+		return exitCycle
+	}
+	return exitDogfile
+}
+
+// runExitStatus returns the exit status for the result of a task chain
+// execution, printing the reason of the failure to stderr.
+func runExitStatus(result *dog.ChainResult, err error) int {
+	status, reason := exitStatus(result, err)
+	if reason != "" {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", reason)
+	}
+	return status
+}
+
+// exitStatus returns the exit status for the result of a task chain
+// execution and the reason of the failure, if any.
+func exitStatus(result *dog.ChainResult, err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	var timeoutErr *dog.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return exitTimeout, err.Error()
+	}
+
+	t := result.Failed()
+	var sigErr *dog.SignalError
+	if errors.As(err, &sigErr) && (t == nil || t.ExitStatus == 0) {
+		if s, ok := sigErr.Signal.(syscall.Signal); ok {
+			return exitSignal + int(s), err.Error()
+		}
+		return exitRunner, err.Error()
+	}
+
+	switch {
+	case t == nil:
+		return exitRunner, err.Error()
+	case t.Signal != 0:
+		return exitSignal + int(t.Signal), fmt.Sprintf("task %q killed by signal %d (%s)", t.Name, t.Signal, t.Signal)
+	case t.ExitStatus > 0:
+		return t.ExitStatus, fmt.Sprintf("task %q failed with exit status %d", t.Name, t.ExitStatus)
+	default:
+		return exitRunner, fmt.Sprintf("task %q failed: %s", t.Name, err)
+	}
+}
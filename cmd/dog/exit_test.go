@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dogtools/dog"
+)
+
+// otherSignal is a signal that isn't a syscall.Signal.
+type otherSignal struct{}
+
+func (otherSignal) String() string { return "other" }
+func (otherSignal) Signal()        {}
+
+func TestExitStatus(t *testing.T) {
+	failed := func(exitStatus int, signal syscall.Signal) *dog.ChainResult {
+		return &dog.ChainResult{Tasks: []dog.TaskResult{
+			{Name: "pre", State: dog.TaskSucceeded},
+			{Name: "foo", State: dog.TaskFailed, ExitStatus: exitStatus, Signal: signal},
+		}}
+	}
+	interrupted := fmt.Errorf("Task %q interrupted: %w", "foo", &dog.SignalError{Signal: syscall.SIGINT})
+
+	for i, test := range []struct {
+		name   string
+		result *dog.ChainResult
+		err    error
+		status int
+	}{
+		{"success", &dog.ChainResult{}, nil, 0},
+		{"exit status", failed(3, 0), errors.New("exit status 3"), 3},
+		{"killed task", failed(-1, syscall.SIGKILL), errors.New("signal: killed"), 137},
+		{"timeout", failed(-1, syscall.SIGTERM), &dog.TimeoutError{Task: "foo", Timeout: time.Second}, exitTimeout},
+		{"signal received", failed(0, 0), interrupted, 130},
+		{"signal received by a failed task", failed(2, 0), interrupted, 2},
+		{"signal without number", failed(0, 0), &dog.SignalError{Signal: otherSignal{}}, exitRunner},
+		{"task without exit status", failed(0, 0), errors.New("Runner not specified"), exitRunner},
+		{"no failed task", &dog.ChainResult{}, errors.New("Runner not specified"), exitRunner},
+	} {
+		if status, _ := exitStatus(test.result, test.err); status != test.status {
+			t.Errorf("Test %d (%s): expected exit status %d but was %d", i, test.name, test.status, status)
+		}
+	}
+}
+
+func TestDogfileExitStatus(t *testing.T) {
+	for i, test := range []struct {
+		err    error
+		status int
+	}{
+		{dog.ErrNoDogfile, exitDogfile},
+		{errors.New("Duplicated task name foo"), exitDogfile},
+		{&dog.CycleError{Path: []string{"foo", "bar", "foo"}}, exitCycle},
+		{fmt.Errorf("Task bar: %w", dog.ErrCycleInTaskChain), exitCycle},
+	} {
+		if status := dogfileExitStatus(test.err); status != test.status {
+			t.Errorf("Test %d (%v): expected exit status %d but was %d", i, test.err, test.status, status)
+		}
+	}
+}
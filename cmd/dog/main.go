@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
 
 	"github.com/dogtools/dog"
 )
@@ -15,8 +19,7 @@ func main() {
 	// parse cli arguments
 	a, err := parseArgs(os.Args[1:])
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fail(exitUsage, err)
 	}
 
 	if a.help {
@@ -34,11 +37,17 @@ func main() {
 		fmt.Fprintf(os.Stderr, "[dog-debug] info: %v\n", a.info)
 	}
 
+	if a.workspace {
+		os.Exit(runWorkspace(a))
+	}
+
 	// parse dogfile
 	dtasks, err := dog.ParseFromDisk(a.directory)
-	if err != nil {
+	if err == dog.ErrNoDogfile {
 		printNoValidDogfile()
-		os.Exit(1)
+		os.Exit(exitDogfile)
+	} else if err != nil {
+		fail(dogfileExitStatus(err), fmt.Errorf("Error: invalid Dogfile: %s", err))
 	}
 
 	if a.debug {
@@ -47,20 +56,23 @@ func main() {
 	}
 
 	if a.taskName != "" {
-		if a.info {
-			dog.ProvideExtraInfo = true
-		}
-
 		if dtasks.Tasks[a.taskName] == nil {
-			fmt.Println("Unknown task name:", a.taskName)
-			os.Exit(1)
+			fail(exitUnknownTask, fmt.Errorf("Unknown task name: %s", a.taskName))
 		}
 
 		// generate task chain
 		taskChain, err := dog.NewTaskChain(dtasks, a.taskName)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fail(dogfileExitStatus(err), fmt.Errorf("Error: %s", err))
+		}
+
+		// bind task arguments to task parameters
+		taskChain.Params, err = parseParams(a.taskArgs)
+		if err == nil {
+			err = taskChain.CheckParams()
+		}
+		if err != nil {
+			fail(exitUsage, err)
 		}
 		if a.debug {
 			var chain string
@@ -68,20 +80,61 @@ func main() {
 				chain += fmt.Sprintf("%s ", t.Name)
 			}
 			fmt.Fprintf(os.Stderr, "[dog-debug] chain: %s\n", chain)
+			fmt.Fprintf(os.Stderr, "[dog-debug] collapsed: %v\n", taskChain.Collapsed)
+			fmt.Fprintf(os.Stderr, "[dog-debug] params: %v\n", taskChain.Params)
 		}
 
-		// run task chain
-		err = taskChain.Run(os.Stdout, os.Stderr)
-		if err != nil {
-			os.Exit(2)
+		taskChain.Jobs = a.jobs
+		taskChain.ShowRegistered = a.showRegs
+		if a.info {
+			taskChain.Observers = append(taskChain.Observers, printInfo(os.Stdout))
 		}
 
+		// run task chain
+		ctx, kill := signalContext()
+		taskChain.Kill = kill
+		result, err := taskChain.RunContext(ctx, os.Stdout, os.Stderr)
+		os.Exit(runExitStatus(result, err))
+
 	} else {
 		printTasks(dtasks)
 		os.Exit(0)
 	}
 }
 
+// signalContext returns a context that is cancelled when dog receives a
+// signal, so the signal is forwarded to the running tasks and dog waits
+// for them to exit.
+//
+// The returned channel is closed when a second signal is received, so the
+// tasks still running are killed without waiting for them any longer.
+func signalContext() (context.Context, <-chan struct{}) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	kill := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		cancel(&dog.SignalError{Signal: <-signals})
+		<-signals
+		close(kill)
+	}()
+	return ctx, kill
+}
+
+// print execution info (duration, exit status) after task execution
+func printInfo(w io.Writer) dog.Observer {
+	return dog.ObserverFunc(func(e dog.Event) {
+		if t, ok := e.(dog.TaskFinished); ok {
+			verb := "finished"
+			if t.State == dog.TaskFailed {
+				verb = "failed"
+			}
+			fmt.Fprintf(w, "-- %s (%s) %s with exit status %d\n",
+				t.Task, t.Duration.String(), verb, t.ExitStatus)
+		}
+	})
+}
+
 // print tasks with description
 func printTasks(dtasks dog.Dogtasks) {
 	maxCharSize := 0
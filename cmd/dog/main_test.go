@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain runs dog itself when the test binary is started by a test with
+// DOG_TEST_MAIN set, so tests can drive it through a terminal.
+func TestMain(m *testing.M) {
+	if os.Getenv("DOG_TEST_MAIN") != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,131 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+func TestInterruptParallelTasksFromTerminal(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		args     []string
+		dogfiles map[string]string
+	}{
+		{
+			"jobs",
+			[]string{"-j", "2", "all"},
+			map[string]string{"dog.yml": `
+- task: a
+  code: touch DIR/a-started; sleep 5; touch DIR/a-finished
+- task: b
+  code: touch DIR/b-started; sleep 5; touch DIR/b-finished
+- task: all
+  parallel: true
+  pre: [a, b]
+  code: echo all
+`},
+		},
+		{
+			"projects",
+			[]string{"-r", "-p", "2", "all"},
+			map[string]string{
+				"a/dog.yml": "- task: all\n  code: touch DIR/a-started; sleep 5; touch DIR/a-finished\n",
+				"b/dog.yml": "- task: all\n  code: touch DIR/b-started; sleep 5; touch DIR/b-finished\n",
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, dogfile := range test.dogfiles {
+				p := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				dogfile = strings.ReplaceAll(dogfile, "DIR", dir)
+				if err := os.WriteFile(p, []byte(dogfile), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			interruptFromTerminal(t, dir, test.args...)
+		})
+	}
+}
+
+// interruptFromTerminal runs dog in a pseudo-terminal, presses Ctrl-C once
+// tasks a and b are running and checks that both of them are stopped. The
+// tasks create files in dir when they start and when they finish.
+func interruptFromTerminal(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "DOG_TEST_MAIN=1")
+	terminal, err := pty.Start(cmd)
+	if err != nil {
+		t.Skipf("No pseudo-terminal available: %v", err)
+	}
+	defer terminal.Close()
+
+	var mu sync.Mutex
+	var out bytes.Buffer
+	go func() {
+		b := make([]byte, 1024)
+		for {
+			n, err := terminal.Read(b)
+			mu.Lock()
+			out.Write(b[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	output := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return out.String()
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	start := time.Now()
+	for !exists("a-started") || !exists("b-started") {
+		if time.Since(start) > 3*time.Second {
+			cmd.Process.Kill()
+			t.Fatalf("Tasks didn't start: %q", output())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err = terminal.Write([]byte{3}); err != nil { // Ctrl-C
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("Dog didn't exit after Ctrl-C: %q", output())
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("Expected every task to stop after Ctrl-C but dog took %s", d)
+	}
+	if err == nil {
+		t.Errorf("Expected dog to fail after Ctrl-C")
+	}
+	if exists("a-finished") || exists("b-finished") {
+		t.Errorf("Expected no task to finish after Ctrl-C but the output was %q", output())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dogtools/dog"
+)
+
+// projectRun holds the outcome of running a task in a workspace project.
+type projectRun struct {
+	name     string
+	started  bool
+	status   int
+	reason   string
+	duration time.Duration
+}
+
+// runWorkspace runs a task in every project of the workspace that defines
+// it, prints a summary of the results and returns the exit status of dog:
+// the exit status of the first project that failed, if any.
+func runWorkspace(a userArgs) int {
+	root := a.directory
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		fail(exitUsage, fmt.Errorf("Error: %s", err))
+	}
+	dirs, err := dog.FindProjects(root)
+	if err != nil {
+		fail(exitDogfile, fmt.Errorf("Error: %s", err))
+	}
+
+	params, err := parseParams(a.taskArgs)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+
+	// only projects defining the task take part in the run, projects
+	// with invalid Dogfiles fail unless they are excluded
+	var projects []*projectRun
+	var dtasks []dog.Dogtasks
+	for _, dir := range dirs {
+		name, _ := filepath.Rel(root, dir)
+		if !selectProject(name, a.include, a.exclude) {
+			continue
+		}
+		d, err := dog.ParseFromDisk(dir)
+		if err == nil && d.Tasks[a.taskName] == nil {
+			continue
+		}
+		p := &projectRun{name: name}
+		if err != nil {
+			p.started = true
+			p.status, p.reason = dogfileExitStatus(err), fmt.Sprintf("invalid Dogfile: %s", err)
+			fmt.Fprintf(os.Stdout, "== %s\n", p.name)
+			fmt.Fprintf(os.Stderr, "Error: %s\n", p.reason)
+		}
+		projects = append(projects, p)
+		dtasks = append(dtasks, d)
+	}
+	if len(projects) == 0 {
+		fail(exitUnknownTask, fmt.Errorf("Unknown task name in workspace: %s", a.taskName))
+	}
+	if a.debug {
+		fmt.Fprintf(os.Stderr, "[dog-debug] workspace: %s\n", root)
+		fmt.Fprintf(os.Stderr, "[dog-debug] projects: %d\n", len(projects))
+	}
+
+	ctx, kill := signalContext()
+
+	// projects running at the same time keep their output until they
+	// finish, so it isn't mixed with the output of other projects
+	var output sync.Mutex
+	var running sync.WaitGroup
+	slots := make(chan struct{}, a.projects)
+	for i, p := range projects {
+		if p.started {
+			continue
+		}
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		p.started = true
+		running.Add(1)
+		go func(p *projectRun, d dog.Dogtasks) {
+			defer func() {
+				<-slots
+				running.Done()
+			}()
+
+			var stdout, stderr io.Writer = os.Stdout, os.Stderr
+			outBuf, errBuf := new(bytes.Buffer), new(bytes.Buffer)
+			if a.projects > 1 {
+				stdout, stderr = outBuf, errBuf
+			} else {
+				output.Lock()
+				defer output.Unlock()
+			}
+
+			fmt.Fprintf(stdout, "== %s\n", p.name)
+			start := time.Now()
+			runProject(ctx, kill, a, d, params, p, stdout, stderr)
+			p.duration = time.Since(start)
+			if p.reason != "" {
+				fmt.Fprintf(stderr, "Error: %s\n", p.reason)
+			}
+
+			if a.projects > 1 {
+				output.Lock()
+				defer output.Unlock()
+				os.Stdout.Write(outBuf.Bytes())
+				os.Stderr.Write(errBuf.Bytes())
+			}
+		}(p, dtasks[i])
+	}
+	running.Wait()
+
+	printSummary(os.Stdout, projects)
+	for _, p := range projects {
+		if p.status != 0 {
+			return p.status
+		}
+	}
+	for _, p := range projects {
+		if !p.started {
+			return exitRunner
+		}
+	}
+	return 0
+}
+
+// runProject runs the task chain of a workspace project, recording its
+// exit status and the reason of its failure in p.
+func runProject(ctx context.Context, kill <-chan struct{}, a userArgs, dtasks dog.Dogtasks, params map[string]string, p *projectRun, stdout, stderr io.Writer) {
+	taskChain, err := dog.NewTaskChain(dtasks, a.taskName)
+	if err != nil {
+		p.status, p.reason = dogfileExitStatus(err), err.Error()
+		return
+	}
+	taskChain.Params = params
+	if err = taskChain.CheckParams(); err != nil {
+		p.status, p.reason = exitUsage, err.Error()
+		return
+	}
+	taskChain.Jobs = a.jobs
+	taskChain.ShowRegistered = a.showRegs
+	taskChain.Kill = kill
+	taskChain.Background = a.projects > 1
+	if a.info {
+		taskChain.Observers = append(taskChain.Observers, printInfo(stdout))
+	}
+
+	result, err := taskChain.RunContext(ctx, stdout, stderr)
+	p.status, p.reason = exitStatus(result, err)
+}
+
+// selectProject reports whether a project takes part in a workspace run.
+// Globs are matched against the path of the project relative to the root
+// of the workspace and against its directory name.
+func selectProject(name string, include, exclude []string) bool {
+	matches := func(globs []string) bool {
+		for _, g := range globs {
+			if ok, _ := filepath.Match(g, name); ok {
+				return true
+			}
+			if ok, _ := filepath.Match(g, filepath.Base(name)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(include) > 0 && !matches(include) {
+		return false
+	}
+	return !matches(exclude)
+}
+
+// printSummary prints a table with the result of every project.
+func printSummary(w io.Writer, projects []*projectRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nPROJECT\tRESULT\tDURATION")
+	var passed, failed, skipped int
+	for _, p := range projects {
+		result := "passed"
+		switch {
+		case !p.started:
+			result = "skipped"
+			skipped++
+		case p.status != 0:
+			result = fmt.Sprintf("failed (%d)", p.status)
+			failed++
+		default:
+			passed++
+		}
+		duration := "-"
+		if p.duration > 0 {
+			duration = p.duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.name, result, duration)
+	}
+	tw.Flush()
+
+	var counts []string
+	counts = append(counts, fmt.Sprintf("%d passed", passed))
+	counts = append(counts, fmt.Sprintf("%d failed", failed))
+	if skipped > 0 {
+		counts = append(counts, fmt.Sprintf("%d skipped", skipped))
+	}
+	fmt.Fprintln(w, strings.Join(counts, ", "))
+}
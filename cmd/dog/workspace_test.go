@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dogtools/dog"
+)
+
+func TestSelectProject(t *testing.T) {
+	for i, test := range []struct {
+		name     string
+		include  []string
+		exclude  []string
+		selected bool
+	}{
+		{"api", nil, nil, true},
+		{"services/api", []string{"services/*"}, nil, true},
+		{"services/api", []string{"api"}, nil, true},
+		{"services/api", []string{"web"}, nil, false},
+		{"services/api", []string{"web", "a*"}, nil, true},
+		{"legacy-api", nil, []string{"legacy-*"}, false},
+		{"services/legacy-api", nil, []string{"legacy-*"}, false},
+		{"services/legacy-api", []string{"services/*"}, []string{"legacy-*"}, false},
+		{"services/api", []string{"services/*"}, []string{"legacy-*"}, true},
+		{"services/api/v2", []string{"services/*"}, nil, false},
+	} {
+		if got := selectProject(test.name, test.include, test.exclude); got != test.selected {
+			t.Errorf("Test %d: expected %s with include %q and exclude %q to be selected %t but was %t",
+				i, test.name, test.include, test.exclude, test.selected, got)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	for i, test := range []struct {
+		projects []*projectRun
+		summary  string
+	}{
+		{
+			[]*projectRun{
+				{name: "api", started: true, duration: 1500 * time.Millisecond},
+				{name: "web", started: true},
+			},
+			"\nPROJECT  RESULT  DURATION\n" +
+				"api      passed  1.5s\n" +
+				"web      passed  -\n" +
+				"2 passed, 0 failed\n",
+		},
+		{
+			[]*projectRun{
+				{name: "api", started: true, status: 2, duration: time.Second},
+				{name: "broken", started: true, status: exitDogfile},
+				{name: "web"},
+			},
+			"\nPROJECT  RESULT       DURATION\n" +
+				"api      failed (2)   1s\n" +
+				"broken   failed (65)  -\n" +
+				"web      skipped      -\n" +
+				"0 passed, 2 failed, 1 skipped\n",
+		},
+	} {
+		var b bytes.Buffer
+		printSummary(&b, test.projects)
+		if got := b.String(); got != test.summary {
+			t.Errorf("Test %d: expected summary %q but was %q", i, test.summary, got)
+		}
+	}
+}
+
+func TestRunProjectWorkdir(t *testing.T) {
+	root := t.TempDir()
+	app := filepath.Join(root, "svc", "app")
+	if err := os.MkdirAll(app, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dogfile := "- task: where\n  workdir: app\n  code: pwd\n"
+	if err := os.WriteFile(filepath.Join(root, "svc", "dog.yml"), []byte(dogfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// workspace runs start from the root of the workspace
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	dtasks, err := dog.ParseFromDisk(filepath.Join(root, "svc"))
+	if err != nil {
+		t.Fatalf("Failed parsing the project: %v", err)
+	}
+	var out bytes.Buffer
+	p := &projectRun{name: "svc"}
+	a := userArgs{jobs: 1, projects: 1, taskName: "where"}
+	runProject(context.Background(), nil, a, dtasks, nil, p, &out, &out)
+	if p.status != 0 {
+		t.Fatalf("Expected the project to pass but it failed with %d: %s", p.status, p.reason)
+	}
+	got, err := filepath.EvalSymlinks(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := filepath.EvalSymlinks(app)
+	if got != want {
+		t.Errorf("Expected the task to run in %s but it ran in %s", want, got)
+	}
+}
+
+func TestRunWorkspaceParallelOutput(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"api", "web"} {
+		dogfile := "- task: check\n  code: echo " + name + "-out; echo " + name + "-err >&2; exit 3\n"
+		if err := os.MkdirAll(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name, "dog.yml"), []byte(dogfile), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-r", "-p", "2", "check")
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "DOG_TEST_MAIN=1")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatalf("Expected the workspace run to fail")
+	}
+
+	for _, name := range []string{"api", "web"} {
+		if !strings.Contains(stdout.String(), name+"-out") || strings.Contains(stdout.String(), name+"-err") {
+			t.Errorf("Expected only the output of %s on stdout but it was %q", name, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), name+"-err") || strings.Contains(stderr.String(), name+"-out") {
+			t.Errorf("Expected only the errors of %s on stderr but it was %q", name, stderr.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "Error:") || !strings.Contains(stderr.String(), "Error:") {
+		t.Errorf("Expected the failures on stderr but stdout was %q and stderr was %q", stdout.String(), stderr.String())
+	}
+}
@@ -0,0 +1,100 @@
+package dog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CycleError means that there is a loop in the path of tasks execution.
+//
+// Path lists the tasks that form the loop, starting and ending with the
+// same task.
+type CycleError struct {
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrCycleInTaskChain, strings.Join(e.Path, " -> "))
+}
+
+// Is makes errors.Is report a CycleError as ErrCycleInTaskChain.
+func (e *CycleError) Is(target error) bool {
+	return target == ErrCycleInTaskChain
+}
+
+// Colors used to mark tasks during the depth-first search.
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// checkReferences walks the graph formed by the pre and post hooks of the
+// given tasks and returns an error if a hook references a task that does
+// not exist or if the graph contains a cycle.
+//
+// Every task is visited once, no matter how many tasks reference it.
+func (dtasks *Dogtasks) checkReferences(tasks []string) error {
+	color := make(map[string]int)
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		t, found := dtasks.Tasks[name]
+		if !found && isPathReference(name) && !dtasks.loaded {
+			// tasks in other directories are checked once
+			// their Dogfiles are loaded
+			color[name] = visited
+			return nil
+		}
+		if !found {
+			return fmt.Errorf("Task %q does not exist", name)
+		}
+
+		color[name] = visiting
+		path = append(path, name)
+
+		hooks := append(append([]string{}, t.Pre...), t.Post...)
+		for _, next := range hooks {
+			switch color[next] {
+			case visiting:
+				var cycle []string
+				for i := range path {
+					if path[i] == next {
+						cycle = append(cycle, path[i:]...)
+						break
+					}
+				}
+				return &CycleError{Path: append(cycle, next)}
+			case unvisited:
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		color[name] = visited
+		return nil
+	}
+
+	for _, name := range tasks {
+		if color[name] == unvisited {
+			if err := visit(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortedNames returns the names of all tasks in alphabetical order.
+func (dtasks *Dogtasks) sortedNames() []string {
+	var names []string
+	for name := range dtasks.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,147 @@
+package dog
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Observer receives the events generated during a task chain execution.
+//
+// Events are delivered one at a time and in the order they happen, so
+// implementations don't need to be safe for concurrent use. Notify should
+// return quickly, as it blocks the task chain execution.
+type Observer interface {
+	Notify(e Event)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions
+// as observers.
+type ObserverFunc func(e Event)
+
+// Notify calls f(e).
+func (f ObserverFunc) Notify(e Event) {
+	f(e)
+}
+
+// Event is implemented by every event sent to observers: ChainStarted,
+// TaskStarted, TaskOutput, TaskRegistered, TaskFinished and ChainFinished.
+type Event interface {
+	event()
+}
+
+// ChainStarted is sent when a task chain starts running.
+type ChainStarted struct {
+	Tasks []string
+	Time  time.Time
+}
+
+// TaskStarted is sent when a task starts running.
+type TaskStarted struct {
+	Task string
+	Time time.Time
+}
+
+// Stream identifies the output stream of a task.
+type Stream int
+
+const (
+	// Stdout is the standard output of a task.
+	Stdout Stream = iota
+
+	// Stderr is the standard error of a task.
+	Stderr
+)
+
+func (s Stream) String() string {
+	if s == Stderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+// TaskOutput is sent for every line written by a task, without the
+// trailing newline. Output stored in a register is also sent.
+type TaskOutput struct {
+	Task   string
+	Line   string
+	Stream Stream
+}
+
+// TaskRegistered is sent when a task stores its output in a register.
+type TaskRegistered struct {
+	Task     string
+	Register string
+	Value    string
+}
+
+// TaskFinished is sent when a task finishes running, either successfully
+// or with an error.
+type TaskFinished struct {
+	Task       string
+	State      TaskState
+	ExitStatus int
+	Duration   time.Duration
+	Err        error
+}
+
+// ChainFinished is sent when a task chain finishes running.
+type ChainFinished struct {
+	Result *ChainResult
+	Err    error
+}
+
+func (ChainStarted) event()   {}
+func (TaskStarted) event()    {}
+func (TaskOutput) event()     {}
+func (TaskRegistered) event() {}
+func (TaskFinished) event()   {}
+func (ChainFinished) event()  {}
+
+// notifier delivers events to a group of observers, one at a time.
+type notifier struct {
+	mu        sync.Mutex
+	observers []Observer
+}
+
+func (n *notifier) notify(e Event) {
+	if len(n.observers) == 0 {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, o := range n.observers {
+		o.Notify(e)
+	}
+}
+
+// outputWriter splits the output of a task into lines and sends each
+// of them to the observers.
+type outputWriter struct {
+	notifier *notifier
+	task     string
+	stream   Stream
+	buf      []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.buf[:i], []byte("\r"))
+		w.notifier.notify(TaskOutput{Task: w.task, Line: string(line), Stream: w.stream})
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush sends the last line of output if it doesn't end with a newline.
+func (w *outputWriter) flush() {
+	if len(w.buf) > 0 {
+		w.notifier.notify(TaskOutput{Task: w.task, Line: string(w.buf), Stream: w.stream})
+		w.buf = nil
+	}
+}
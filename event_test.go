@@ -0,0 +1,70 @@
+package dog
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTaskChainObserver(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"get-animal": {
+				Name:     "get-animal",
+				Runner:   "sh",
+				Code:     "echo dog",
+				Register: "ANIMAL",
+			},
+			"main": {
+				Name:   "main",
+				Runner: "sh",
+				Pre:    []string{"get-animal"},
+				Code:   "echo \"hello $ANIMAL\"; printf 'oops' >&2",
+			},
+		},
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "main")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+
+	var got []string
+	taskChain.Observers = []Observer{ObserverFunc(func(e Event) {
+		switch e := e.(type) {
+		case ChainStarted:
+			got = append(got, fmt.Sprintf("chain started %v", e.Tasks))
+		case TaskStarted:
+			got = append(got, "started "+e.Task)
+		case TaskOutput:
+			got = append(got, fmt.Sprintf("%s %s: %s", e.Stream, e.Task, e.Line))
+		case TaskRegistered:
+			got = append(got, fmt.Sprintf("registered %s=%s", e.Register, e.Value))
+		case TaskFinished:
+			got = append(got, fmt.Sprintf("finished %s %s", e.Task, e.State))
+		case ChainFinished:
+			got = append(got, fmt.Sprintf("chain finished %v", e.Err))
+		}
+	})}
+
+	if _, err = taskChain.Run(new(bytes.Buffer), new(bytes.Buffer)); err != nil {
+		t.Fatalf("Failed running a task chain: %v", err)
+	}
+
+	want := []string{
+		"chain started [get-animal main]",
+		"started get-animal",
+		"stdout get-animal: dog",
+		"registered ANIMAL=dog",
+		"finished get-animal succeeded",
+		"started main",
+		"stdout main: hello dog",
+		"stderr main: oops",
+		"finished main succeeded",
+		"chain finished <nil>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %q but was %q", want, got)
+	}
+}
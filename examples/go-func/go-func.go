@@ -0,0 +1,56 @@
+package main
+
+// This example shows how to add a task that runs a Go function to the
+// tasks defined in a Dogfile, so that it can be used in the same chains.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dogtools/dog"
+)
+
+func main() {
+
+	// Define a task in the Dogfile format using YAML
+	dogfileYAML := `
+- task: build
+  description: Build the project
+  code: echo "Building..."
+`
+
+	// Parse Dogfile
+	dtasks, err := dog.Parse([]byte(dogfileYAML))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Add a task that runs a Go function after building the project
+	migrate, err := dtasks.AddFunc("migrate", func(ctx context.Context, env []string, stdout, stderr io.Writer) error {
+		fmt.Fprintln(stdout, "Migrating database...")
+		return ctx.Err()
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	migrate.Description = "Migrate the database"
+	migrate.Pre = []string{"build"}
+
+	// Generate task chain that runs 'build' and then 'migrate'
+	taskChain, err := dog.NewTaskChain(dtasks, "migrate")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Run task chain
+	_, err = taskChain.Run(os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+}
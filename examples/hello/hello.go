@@ -39,7 +39,7 @@ func main() {
 	}
 
 	// Run task chain
-	err = taskChain.Run(os.Stdout, os.Stderr)
+	_, err = taskChain.Run(os.Stdout, os.Stderr)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(2)
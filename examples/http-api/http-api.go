@@ -42,14 +42,15 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	taskChain, err := dog.NewTaskChain(dtasks, taskName)
 	if err != nil {
 		fmt.Fprintf(w, "task chain generation failed: %s\n", err)
-		os.Exit(1)
+		return
 	}
 
-	// Run task chain, HTTP client receives info about how task finished
-	err = taskChain.Run(os.Stdout, os.Stderr)
+	// Run task chain, HTTP client receives info about how task finished.
+	// The execution is stopped if the client goes away.
+	result, err := taskChain.RunContext(r.Context(), os.Stdout, os.Stderr)
 	if err != nil {
 		fmt.Fprintf(w, "%s failed: %s\n", taskName, err)
-		os.Exit(2)
+		return
 	}
-	fmt.Fprintf(w, "%s finished\n", taskName)
+	fmt.Fprintf(w, "%s finished in %s\n", taskName, result.Duration())
 }
@@ -0,0 +1,132 @@
+package dog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resolveExtends applies the extends directive of the tasks in the Dogfiles
+// of a directory.
+//
+// A task extending a task or a template of the same directory inherits its
+// runner, code, workdir, pre and post hooks unless it sets them, and its
+// environment variables merged by name. Templates are only used to be
+// extended, they are not added to the Dogfile tasks.
+func resolveExtends(tasks []*taskYAML, templates map[string]*taskYAML) error {
+	bases := make(map[string]*taskYAML, len(tasks)+len(templates))
+	for name, t := range templates {
+		if !validTaskName(name) {
+			return fmt.Errorf("Invalid name for template %s", name)
+		}
+		t.Name = name
+		bases[name] = t
+	}
+	for _, t := range tasks {
+		if _, ok := templates[t.Name]; ok {
+			return fmt.Errorf("Task %s has the same name as a template", t.Name)
+		}
+		bases[t.Name] = t
+	}
+
+	color := make(map[string]int)
+	var path []string
+	var visit func(t *taskYAML) error
+	visit = func(t *taskYAML) error {
+		color[t.Name] = visiting
+		path = append(path, t.Name)
+
+		if t.Extends != "" {
+			base, ok := bases[t.Extends]
+			if !ok {
+				return fmt.Errorf("Task %s extends %s, which does not exist", t.Name, t.Extends)
+			}
+			switch color[base.Name] {
+			case visiting:
+				for i, name := range path {
+					if name == base.Name {
+						path = append(path[i:], base.Name)
+						break
+					}
+				}
+				return fmt.Errorf("Cycle of extended tasks: %s", strings.Join(path, " -> "))
+			case unvisited:
+				if err := visit(base); err != nil {
+					return err
+				}
+			}
+			if err := t.inherit(base); err != nil {
+				return fmt.Errorf("Task %s: %v", t.Name, err)
+			}
+		}
+
+		color[t.Name] = visited
+		path = path[:len(path)-1]
+		return nil
+	}
+
+	names := make([]string, 0, len(bases))
+	for name := range bases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if color[name] == unvisited {
+			if err := visit(bases[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inherit copies the directives of base that are not set in t, once base
+// has inherited from its own base.
+func (t *taskYAML) inherit(base *taskYAML) error {
+	if t.Code == "" {
+		t.Code = base.Code
+	}
+	if t.Runner.name == "" && t.Runner.spec == nil {
+		t.Runner = base.Runner
+	}
+	if t.Workdir == "" {
+		t.Workdir = base.Workdir
+	}
+	if t.Pre == nil {
+		t.Pre = base.Pre
+	}
+	if t.Post == nil {
+		t.Post = base.Post
+	}
+
+	baseEnv, err := parseStringSlice(base.Env)
+	if err != nil {
+		return err
+	}
+	env, err := parseStringSlice(t.Env)
+	if err != nil {
+		return err
+	}
+	t.Env = mergeEnv(baseEnv, env)
+	return nil
+}
+
+// mergeEnv merges two lists of environment variables. Variables in env
+// replace the variables in base with the same name, keeping their order.
+func mergeEnv(base, env []string) []string {
+	merged := append([]string{}, base...)
+	index := make(map[string]int, len(merged))
+	for i, e := range merged {
+		index[strings.SplitN(e, "=", 2)[0]] = i
+	}
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		if i, ok := index[name]; ok {
+			merged[i] = e
+		} else {
+			index[name] = len(merged)
+			merged = append(merged, e)
+		}
+	}
+	return merged
+}
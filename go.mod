@@ -0,0 +1,12 @@
+module github.com/dogtools/dog
+
+go 1.20
+
+require (
+	github.com/creack/pty v1.1.21
+	github.com/ghodss/yaml v1.0.1-0.20190212211648-25d852aebe32
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
+)
+
+require gopkg.in/yaml.v2 v2.2.2 // indirect
@@ -0,0 +1,170 @@
+package dog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NamespaceSeparator separates the namespace of an included task from
+// its name, as in shared:build.
+const NamespaceSeparator = ":"
+
+// ErrIncludeCycle means that a Dogfile includes itself, directly or
+// through other Dogfiles.
+var ErrIncludeCycle = errors.New("Dogfile includes itself")
+
+// includeYAML represents a Dogfile included by another one.
+type includeYAML struct {
+	Path string `json:"path"`
+	As   string `json:"as"`
+}
+
+// includeField represents the include directive of a Dogfile, which is
+// either a single include or an array of them.
+type includeField []includeYAML
+
+// UnmarshalJSON accepts both forms of the include directive.
+func (f *includeField) UnmarshalJSON(data []byte) error {
+	var inc includeYAML
+	if err := json.Unmarshal(data, &inc); err == nil {
+		*f = includeField{inc}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]includeYAML)(f))
+}
+
+// include parses the Dogfiles found in the path of inc and adds their
+// tasks under its namespace, so task build becomes shared:build and the
+// hooks of the included tasks refer to tasks in the same namespace.
+//
+// Relative paths are relative to dir, the directory of the including
+// Dogfile. Files lists the Dogfiles being included, to detect cycles.
+func (dtasks *Dogtasks) include(inc includeYAML, dir string, files []string) error {
+	if inc.Path == "" {
+		return errors.New("Include without path")
+	}
+	if !validTaskName(inc.As) {
+		return fmt.Errorf("Invalid namespace %q for include %s", inc.As, inc.Path)
+	}
+
+	p := inc.Path
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	paths, err := includedFiles(p)
+	if err != nil {
+		return err
+	}
+
+	including := append([]string{}, files...)
+	dogfiles := make([][]byte, len(paths))
+	for i, file := range paths {
+		for j, f := range files {
+			if f == file {
+				path := append(append([]string{}, files[j:]...), file)
+				return fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(path, " -> "))
+			}
+		}
+		if dogfiles[i], err = ioutil.ReadFile(file); err != nil {
+			return err
+		}
+		including = append(including, file)
+	}
+
+	// the Dogfiles of an included directory are parsed together
+	includedDir := filepath.Dir(paths[0])
+	included, err := parse(includedDir, dogfiles, including)
+	if err != nil {
+		if errors.Is(err, ErrIncludeCycle) {
+			return err
+		}
+		return fmt.Errorf("Included Dogfile %s: %w", inc.Path, err)
+	}
+
+	dtasks.Files = append(dtasks.Files, paths...)
+	dtasks.Files = append(dtasks.Files, included.Files...)
+	for _, name := range included.sortedNames() {
+		t := included.Tasks[name]
+		t.qualify(inc.As)
+		if t.Workdir == "" {
+			t.Workdir = includedDir
+		} else if !filepath.IsAbs(t.Workdir) {
+			t.Workdir = filepath.Join(includedDir, t.Workdir)
+		}
+
+		if _, ok := dtasks.Tasks[t.Name]; ok {
+			return fmt.Errorf("Duplicated task name %s", t.Name)
+		}
+		if dtasks.Tasks == nil {
+			dtasks.Tasks = make(map[string]*Task)
+		}
+		dtasks.Tasks[t.Name] = t
+	}
+	return nil
+}
+
+// includedFiles returns the Dogfile in path, or every Dogfile in it when
+// path is a directory.
+func includedFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, file := range files {
+		if !file.IsDir() && validDogfileName(file.Name()) {
+			paths = append(paths, filepath.Join(path, file.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoDogfile)
+	}
+	return paths, nil
+}
+
+// namespaced returns the name of a task inside a namespace.
+func namespaced(namespace, name string) string {
+	return namespace + NamespaceSeparator + name
+}
+
+// qualify moves a task into a namespace, together with the hooks that
+// refer to tasks defined next to it. Hooks referring to tasks in other
+// directories are left as they are.
+func (t *Task) qualify(namespace string) {
+	t.Name = namespaced(namespace, t.Name)
+	for _, hooks := range [][]string{t.Pre, t.Post} {
+		for i := range hooks {
+			if !isPathReference(hooks[i]) {
+				hooks[i] = namespaced(namespace, hooks[i])
+			}
+		}
+	}
+}
+
+// validTaskReference checks if a name refers to a task, either defined
+// in the Dogfile or included in a namespace.
+func validTaskReference(name string) bool {
+	for _, part := range strings.Split(name, NamespaceSeparator) {
+		if !validTaskName(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,335 @@
+package dog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	tparse "text/template/parse"
+	"time"
+)
+
+// templateData is the data available to the templates used in the
+// fields of a task.
+type templateData struct {
+	// Vars holds the global variables of the Dogfile.
+	Vars map[string]string
+
+	// Env holds the environment variables defined before the field,
+	// with the values seen by the task.
+	Env map[string]string
+
+	// Params holds the values of the task parameters. They are only
+	// known when the task runs.
+	Params map[string]string
+}
+
+// newTemplate parses the template found in a field of a task. Functions
+// reading from the disk look for files from dir.
+func newTemplate(field, text, dir string) (*template.Template, error) {
+	return template.New(field).
+		Option("missingkey=error").
+		Funcs(templateFuncs(dir)).
+		Parse(text)
+}
+
+// executeTemplate executes the template found in a field. Text without
+// actions is returned as is.
+func executeTemplate(field, text, dir string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := newTemplate(field, text, dir)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// interpolate executes the template found in a field of a task.
+func interpolate(t *Task, field, text, dir string, data templateData) (string, error) {
+	s, err := executeTemplate(field, text, dir, data)
+	if err != nil {
+		return "", fmt.Errorf("Task %s: %v", t.Name, err)
+	}
+	return s, nil
+}
+
+// interpolateFields executes the templates in the description, hooks and
+// working directory of a task, once its Dogfile is parsed. Templates in
+// the environment, and working directories using the task parameters, are
+// checked but they are executed when the task runs.
+func (t *Task) interpolateFields(dir string, data templateData) (err error) {
+	if t.Description, err = interpolateStatic(t, "description", t.Description, dir, data); err != nil {
+		return
+	}
+	for _, hooks := range []struct {
+		field string
+		names []string
+	}{{"pre", t.Pre}, {"post", t.Post}} {
+		for i := range hooks.names {
+			if hooks.names[i], err = interpolateStatic(t, hooks.field, hooks.names[i], dir, data); err != nil {
+				return
+			}
+		}
+	}
+
+	tmpl, err := newTemplate("workdir", t.Workdir, dir)
+	if err != nil {
+		return fmt.Errorf("Task %s: %v", t.Name, err)
+	}
+	if usesParams(tmpl.Root) {
+		t.workdirParams, t.Workdir = t.Workdir, ""
+	} else if t.Workdir, err = interpolate(t, "workdir", t.Workdir, dir, data); err != nil {
+		return
+	}
+
+	for _, e := range t.Env {
+		if _, err = newTemplate("env", e, dir); err != nil {
+			return fmt.Errorf("Task %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// interpolateStatic executes the template found in a field of a task that
+// is needed before the task runs, so it can't use the task parameters.
+func interpolateStatic(t *Task, field, text, dir string, data templateData) (string, error) {
+	if tmpl, err := newTemplate(field, text, dir); err == nil && usesParams(tmpl.Root) {
+		return "", fmt.Errorf("Task %s: %s can't use .Params, parameters are only known when the task runs", t.Name, field)
+	}
+	return interpolate(t, field, text, dir, data)
+}
+
+// usesParams reports whether a template node refers to the task
+// parameters.
+func usesParams(node tparse.Node) bool {
+	switch n := node.(type) {
+	case *tparse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesParams(child) {
+				return true
+			}
+		}
+	case *tparse.ActionNode:
+		return usesParams(n.Pipe)
+	case *tparse.IfNode:
+		return usesParams(n.Pipe) || usesParams(n.List) || usesParams(n.ElseList)
+	case *tparse.RangeNode:
+		return usesParams(n.Pipe) || usesParams(n.List) || usesParams(n.ElseList)
+	case *tparse.WithNode:
+		return usesParams(n.Pipe) || usesParams(n.List) || usesParams(n.ElseList)
+	case *tparse.TemplateNode:
+		return usesParams(n.Pipe)
+	case *tparse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if usesParams(cmd) {
+				return true
+			}
+		}
+	case *tparse.CommandNode:
+		for _, arg := range n.Args {
+			if usesParams(arg) {
+				return true
+			}
+		}
+	case *tparse.ChainNode:
+		return usesParams(n.Node)
+	case *tparse.FieldNode:
+		return n.Ident[0] == "Params"
+	case *tparse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "Params"
+	}
+	return false
+}
+
+// runtimeFields returns the default values of the environment variables
+// of a task and its working directory, executing the templates that use
+// the task parameters. Variables that come from the system are left out,
+// since default values are only used when they are unset.
+func (t *Task) runtimeFields(params []string) (env []string, workdir string, err error) {
+	env, data, err := t.executeEnv(params)
+	if err != nil || t.workdirParams == "" {
+		return env, t.Workdir, err
+	}
+	workdir, err = interpolate(t, "workdir", t.workdirParams, t.Workdir, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if !filepath.IsAbs(workdir) {
+		workdir = filepath.Join(t.Workdir, workdir)
+	}
+	return env, workdir, nil
+}
+
+// executeEnv executes the templates of the environment variables of a
+// task, returning their default values and the data seen by templates
+// executed afterwards.
+func (t *Task) executeEnv(params []string) ([]string, templateData, error) {
+	data := templateData{
+		Vars:   t.Vars,
+		Env:    make(map[string]string),
+		Params: make(map[string]string),
+	}
+	for _, p := range params {
+		name, value, _ := strings.Cut(p, "=")
+		data.Params[name] = value
+	}
+
+	var env []string
+	for _, e := range t.Env {
+		name, value, _ := strings.Cut(e, "=")
+		if system, found := os.LookupEnv(name); found {
+			data.Env[name] = system
+			continue
+		}
+		value, err := interpolate(t, "env", value, t.Workdir, data)
+		if err != nil {
+			return nil, data, err
+		}
+		data.Env[name] = value
+		env = append(env, name+"="+value)
+	}
+	return env, data, nil
+}
+
+// globalEnvMap maps the names of the global variables to the values seen
+// by tasks, executing the templates of the global env in order. Variables
+// set in the system take precedence.
+func (dtasks *Dogtasks) globalEnvMap(dir string) (map[string]string, error) {
+	data := templateData{
+		Vars:   dtasks.Vars,
+		Env:    make(map[string]string),
+		Params: make(map[string]string),
+	}
+	for _, e := range dtasks.globalEnv() {
+		name, value, _ := strings.Cut(e, "=")
+		if system, found := os.LookupEnv(name); found {
+			data.Env[name] = system
+			continue
+		}
+		value, err := executeTemplate("env", value, dir, data)
+		if err != nil {
+			return nil, fmt.Errorf("Global env: %v", err)
+		}
+		data.Env[name] = value
+	}
+	return data.Env, nil
+}
+
+// templateFuncs returns the functions available to templates.
+func templateFuncs(dir string) template.FuncMap {
+	return template.FuncMap{
+		"default": func(def, value string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		},
+		"upper": strings.ToUpper,
+		"env":   os.Getenv,
+		"os":    func() string { return runtime.GOOS },
+		"arch":  func() string { return runtime.GOARCH },
+		"now": func(layout ...string) (string, error) {
+			switch len(layout) {
+			case 0:
+				return time.Now().Format(time.RFC3339), nil
+			case 1:
+				return time.Now().Format(layout[0]), nil
+			}
+			return "", errors.New("now accepts a single layout")
+		},
+		"gitsha": func() (string, error) {
+			return gitSHA(dir)
+		},
+	}
+}
+
+// gitSHA returns the commit checked out in the git repository containing
+// dir, reading it from the .git directory.
+func gitSHA(dir string) (string, error) {
+	gitDir, err := findGitDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	ref, found := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !found {
+		return ref, nil
+	}
+
+	// branches are shared by every worktree of the repository
+	commonDir := gitDir
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(common))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+
+	if sha, err := os.ReadFile(filepath.Join(commonDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(sha)), nil
+	}
+	packed, err := os.ReadFile(filepath.Join(commonDir, "packed-refs"))
+	if err == nil {
+		for _, line := range strings.Split(string(packed), "\n") {
+			if sha, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref {
+				return sha, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No commit found for %s", ref)
+}
+
+// findGitDir returns the .git directory of the repository containing dir.
+// A .git file points to the directory of a worktree or a submodule.
+func findGitDir(dir string) (string, error) {
+	current, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		p := filepath.Join(current, ".git")
+		if info, err := os.Stat(p); err == nil {
+			if info.IsDir() {
+				return p, nil
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return "", err
+			}
+			gitDir, found := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir: ")
+			if !found {
+				return "", fmt.Errorf("Invalid git file %s", p)
+			}
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(current, gitDir)
+			}
+			return gitDir, nil
+		}
+
+		next := filepath.Dir(current)
+		if next == current {
+			return "", fmt.Errorf("No git repository found in %s", dir)
+		}
+		current = next
+	}
+}
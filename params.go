@@ -0,0 +1,106 @@
+package dog
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Param describes a parameter that can be provided to a task at runtime.
+//
+// Parameters are passed to the runner both as positional arguments, in the
+// order they are declared, and as environment variables named after them.
+type Param struct {
+	// Name of the parameter.
+	Name string
+
+	// Default value used when the parameter is not provided at runtime.
+	Default string
+
+	// Required parameters have no default value and must be provided
+	// at runtime.
+	Required bool
+
+	// Choices restricts the values accepted by the parameter.
+	Choices []string
+
+	// Regex is a regular expression that values must match. It is
+	// mutually exclusive with Choices.
+	Regex string
+}
+
+// validateDefinition checks that the parameter is well defined.
+func (p Param) validateDefinition() error {
+	if !validParamName(p.Name) {
+		return fmt.Errorf("Invalid name for parameter %s", p.Name)
+	}
+	if len(p.Choices) > 0 && p.Regex != "" {
+		return fmt.Errorf("Parameter %s can't define both choices and regex", p.Name)
+	}
+	if p.Regex != "" {
+		if _, err := regexp.Compile(p.Regex); err != nil {
+			return fmt.Errorf("Invalid regex for parameter %s: %v", p.Name, err)
+		}
+	}
+	if !p.Required {
+		if err := p.validate(p.Default); err != nil {
+			return fmt.Errorf("Invalid default value: %v", err)
+		}
+	}
+	return nil
+}
+
+// validate checks that a value is accepted by the parameter.
+func (p Param) validate(value string) error {
+	if len(p.Choices) > 0 {
+		for _, c := range p.Choices {
+			if value == c {
+				return nil
+			}
+		}
+		return fmt.Errorf("Parameter %s must be one of %v but was %q",
+			p.Name, p.Choices, value)
+	}
+	if p.Regex != "" {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("Parameter %s must match %s but was %q",
+				p.Name, p.Regex, value)
+		}
+	}
+	return nil
+}
+
+// resolveParams validates the values provided at runtime against the
+// parameters of a task, returning them as positional arguments and as
+// environment variables.
+func resolveParams(t Task, values map[string]string) (args []string, env []string, err error) {
+	for _, p := range t.Params {
+		value, found := values[p.Name]
+		if !found {
+			if p.Required {
+				return nil, nil, fmt.Errorf("Task %q requires parameter %s", t.Name, p.Name)
+			}
+			value = p.Default
+		}
+		if err = p.validate(value); err != nil {
+			return nil, nil, err
+		}
+		args = append(args, value)
+		env = append(env, fmt.Sprintf("%s=%s", p.Name, value))
+	}
+	return
+}
+
+// validParamName checks if a parameter name can be used as the name
+// of an environment variable.
+func validParamName(name string) bool {
+	var match bool
+	match, err := regexp.MatchString("^[A-Za-z_][A-Za-z0-9_]*$", name)
+	if err != nil {
+		return false
+	}
+	return match
+}
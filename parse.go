@@ -1,14 +1,22 @@
 package dog
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/dogtools/dog/run"
 	"github.com/ghodss/yaml"
 )
 
@@ -29,6 +37,17 @@ type Dogtasks struct {
 	// Tasks is used to map task objects by their name.
 	Tasks map[string]*Task
 
+	// Runners maps the runners defined in the Dogfile by their name.
+	Runners map[string]*RunnerSpec
+
+	// Vars holds the global variables defined in the Dogfile, which are
+	// inherited by the environment of every task.
+	Vars map[string]string
+
+	// Env holds the global default values for environment variables,
+	// inherited by every task.
+	Env []string
+
 	// Path is an optional field that stores the directory
 	// where the Dogfile is found.
 	Path string
@@ -36,37 +55,144 @@ type Dogtasks struct {
 	// Files is an optional field that stores the full path
 	// of each Dogfile used to define the Dogtasks object.
 	Files []string
+
+	// loaded is set once the tasks in other directories referenced by
+	// the hooks of a task chain have been added to Tasks.
+	loaded bool
+}
+
+// dogfileYAML represents a Dogfile written as a map, which allows defining
+// other things than tasks, instead of as an array of tasks.
+type dogfileYAML struct {
+	Include   includeField           `json:"include,omitempty"`
+	Vars      map[string]interface{} `json:"vars,omitempty"`
+	Env       interface{}            `json:"env,omitempty"`
+	Runners   map[string]*runnerYAML `json:"runners,omitempty"`
+	Templates map[string]*taskYAML   `json:"templates,omitempty"`
+	Tasks     []*taskYAML            `json:"tasks"`
+}
+
+// runnerYAML represents a runner written in the Dogfile format.
+type runnerYAML struct {
+	Command   interface{} `json:"command"`
+	Extension string      `json:"extension,omitempty"`
+	Env       interface{} `json:"env,omitempty"`
+}
+
+// runnerField represents the runner directive of a task, which is either
+// the name of a runner or the definition of one.
+type runnerField struct {
+	name string
+	spec *runnerYAML
+}
+
+// UnmarshalJSON accepts both forms of the runner directive.
+func (r *runnerField) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.name); err == nil {
+		return nil
+	}
+	r.spec = new(runnerYAML)
+	return json.Unmarshal(data, r.spec)
 }
 
 // TaskYAML represents a task written in the Dogfile format.
 type taskYAML struct {
 	Name        string `json:"task"`
 	Description string `json:"description,omitempty"`
+	Extends     string `json:"extends,omitempty"`
 
 	Code string `json:"code"`
 	Run  string `json:"run"` // backwards compatibility for 'code'
 
-	Runner string `json:"runner,omitempty"`
-	Exec   string `json:"exec,omitempty"` // backwards compatibility for 'runner'
+	Runner runnerField `json:"runner,omitempty"`
+	Exec   string      `json:"exec,omitempty"` // backwards compatibility for 'runner'
 
 	Pre  interface{} `json:"pre,omitempty"`
 	Post interface{} `json:"post,omitempty"`
 	Env  interface{} `json:"env,omitempty"`
 
-	Workdir  string `json:"workdir,omitempty"`
-	Register string `json:"register,omitempty"`
+	Parallel bool `json:"parallel,omitempty"`
+	Always   bool `json:"always,omitempty"`
+
+	Workdir        string `json:"workdir,omitempty"`
+	Register       string `json:"register,omitempty"`
+	RegisterStderr string `json:"register_stderr,omitempty"`
+	RegisterStatus string `json:"register_status,omitempty"`
+	RegisterTee    bool   `json:"register_tee,omitempty"`
+	RegisterFormat string `json:"register_format,omitempty"`
+	RegisterJSON   string `json:"register_json,omitempty"`
+	IgnoreErrors   bool   `json:"ignore_errors,omitempty"`
+
+	Params  []*paramYAML `json:"params,omitempty"`
+	Timeout int          `json:"timeout,omitempty"` // in seconds
+
+	TTY bool `json:"tty,omitempty"`
+}
+
+// paramYAML represents a task parameter written in the Dogfile format.
+type paramYAML struct {
+	Name    string   `json:"name"`
+	Default *string  `json:"default,omitempty"`
+	Choices []string `json:"choices,omitempty"`
+	Regex   string   `json:"regex,omitempty"`
 }
 
 // Parse accepts a slice of bytes and parses it following the Dogfile Spec.
+//
+// The root object of a Dogfile is either an array of tasks or a map that
+// includes the array of tasks under the tasks key. Paths of included
+// Dogfiles are relative to the current directory.
 func Parse(p []byte) (dtasks Dogtasks, err error) {
-	var tasks []*taskYAML
+	return parse(".", [][]byte{p}, nil)
+}
 
-	err = yaml.Unmarshal(p, &tasks)
+// parse parses the Dogfiles found in dir, which are processed together as
+// a single Dogfile. Dir is used to find the Dogfiles they include, and
+// files lists the Dogfiles being included, to detect cycles.
+func parse(dir string, dogfiles [][]byte, files []string) (dtasks Dogtasks, err error) {
+	var dogfile dogfileYAML
+	for _, p := range dogfiles {
+		var d dogfileYAML
+		if d, err = unmarshalDogfile(p); err != nil {
+			return
+		}
+		if err = dogfile.merge(d); err != nil {
+			return
+		}
+	}
+
+	if dtasks.Vars, err = parseVars(dogfile.Vars); err != nil {
+		return
+	}
+	if dtasks.Env, err = parseStringSlice(dogfile.Env); err != nil {
+		return
+	}
+	globalEnv, err := dtasks.globalEnvMap(dir)
 	if err != nil {
 		return
 	}
 
-	for _, parsedTask := range tasks {
+	for name, parsedRunner := range dogfile.Runners {
+		var spec *RunnerSpec
+		if !validTaskName(name) {
+			err = fmt.Errorf("Invalid name for runner %s", name)
+			return
+		}
+		if spec, err = parseRunner(parsedRunner); err != nil {
+			err = fmt.Errorf("Runner %s: %v", name, err)
+			return
+		}
+		if dtasks.Runners == nil {
+			dtasks.Runners = make(map[string]*RunnerSpec)
+		}
+		dtasks.Runners[name] = spec
+	}
+
+	if err = resolveExtends(dogfile.Tasks, dogfile.Templates); err != nil {
+		return
+	}
+
+	for _, parsedTask := range dogfile.Tasks {
 		if _, ok := dtasks.Tasks[parsedTask.Name]; ok {
 			err = fmt.Errorf("Duplicated task name %s", parsedTask.Name)
 			return
@@ -78,9 +204,30 @@ func Parse(p []byte) (dtasks Dogtasks, err error) {
 				Name:        parsedTask.Name,
 				Description: parsedTask.Description,
 				Code:        parsedTask.Code,
-				Runner:      parsedTask.Runner,
+				Runner:      parsedTask.Runner.name,
+				Parallel:    parsedTask.Parallel,
+				Always:      parsedTask.Always,
 				Workdir:     parsedTask.Workdir,
-				Register:    parsedTask.Register,
+				Timeout:     time.Duration(parsedTask.Timeout) * time.Second,
+				TTY:         parsedTask.TTY,
+
+				Register:       parsedTask.Register,
+				RegisterStderr: parsedTask.RegisterStderr,
+				RegisterStatus: parsedTask.RegisterStatus,
+				RegisterTee:    parsedTask.RegisterTee,
+				RegisterFormat: parsedTask.RegisterFormat,
+				RegisterPath:   parsedTask.RegisterJSON,
+				IgnoreErrors:   parsedTask.IgnoreErrors,
+			}
+
+			// selecting a path implies a JSON register
+			if task.RegisterPath != "" && task.RegisterFormat == "" {
+				task.RegisterFormat = RegisterJSON
+			}
+
+			if parsedTask.Timeout < 0 {
+				err = fmt.Errorf("Invalid timeout for task %s", task.Name)
+				return
 			}
 
 			// convert pre-tasks, post-tasks and environment variables
@@ -94,9 +241,54 @@ func Parse(p []byte) (dtasks Dogtasks, err error) {
 			if task.Env, err = parseStringSlice(parsedTask.Env); err != nil {
 				return
 			}
+			task.Vars = dtasks.Vars
+			task.Env = append(dtasks.globalEnv(), task.Env...)
+
+			// parameters are only known when the task runs
+			data := templateData{
+				Vars:   dtasks.Vars,
+				Env:    globalEnv,
+				Params: map[string]string{},
+			}
+			if err = task.interpolateFields(dir, data); err != nil {
+				return
+			}
+
+			// hooks referring to tasks in other directories are
+			// resolved now, their Dogfiles are parsed when needed
+			for _, hooks := range [][]string{task.Pre, task.Post} {
+				for i, hook := range hooks {
+					if !isPathReference(hook) {
+						continue
+					}
+					if hooks[i], err = resolvePathReference(hook, dir); err != nil {
+						err = fmt.Errorf("Task %s: %v", task.Name, err)
+						return
+					}
+				}
+			}
+			if task.Params, err = parseParams(parsedTask.Params); err != nil {
+				err = fmt.Errorf("Task %s: %v", task.Name, err)
+				return
+			}
+
+			// runners defined in the Dogfile take precedence over
+			// registered runners with the same name
+			if parsedTask.Runner.spec != nil {
+				if task.RunnerSpec, err = parseRunner(parsedTask.Runner.spec); err != nil {
+					err = fmt.Errorf("Task %s: %v", task.Name, err)
+					return
+				}
+				task.Runner = strings.Join(task.RunnerSpec.Command, " ")
+			} else if spec, ok := dtasks.Runners[task.Runner]; ok {
+				task.RunnerSpec = spec
+			}
 
-			// set default runner if not specified
-			if task.Runner == "" {
+			// set default runner if not specified, unless the code
+			// defines its own interpreter in a shebang line
+			if task.Runner == "" && run.HasShebang(task.Code) {
+				task.Runner = "auto"
+			} else if task.Runner == "" {
 				task.Runner = DefaultRunner
 			}
 
@@ -107,18 +299,105 @@ func Parse(p []byte) (dtasks Dogtasks, err error) {
 		}
 	}
 
+	for _, inc := range dogfile.Include {
+		if err = dtasks.include(inc, dir, files); err != nil {
+			return
+		}
+	}
+
 	// validate resulting dogtasks object
 	err = dtasks.Validate()
 
 	return
 }
 
+// unmarshalDogfile reads a Dogfile written in any of its two forms.
+func unmarshalDogfile(p []byte) (dogfile dogfileYAML, err error) {
+	j, err := yaml.YAMLToJSON(p)
+	if err != nil {
+		return
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(j), []byte("{")) {
+		err = json.Unmarshal(j, &dogfile)
+	} else {
+		err = json.Unmarshal(j, &dogfile.Tasks)
+	}
+	return
+}
+
+// merge adds the contents of another Dogfile of the same directory.
+// Global variables and runners defined in d replace the ones with the
+// same name, and templates must have different names.
+func (dogfile *dogfileYAML) merge(d dogfileYAML) error {
+	dogfile.Include = append(dogfile.Include, d.Include...)
+	for name, v := range d.Vars {
+		if dogfile.Vars == nil {
+			dogfile.Vars = make(map[string]interface{})
+		}
+		dogfile.Vars[name] = v
+	}
+
+	env, err := parseStringSlice(dogfile.Env)
+	if err != nil {
+		return err
+	}
+	more, err := parseStringSlice(d.Env)
+	if err != nil {
+		return err
+	}
+	dogfile.Env = append(env, more...)
+
+	for name, r := range d.Runners {
+		if dogfile.Runners == nil {
+			dogfile.Runners = make(map[string]*runnerYAML)
+		}
+		dogfile.Runners[name] = r
+	}
+	for name, t := range d.Templates {
+		if _, ok := dogfile.Templates[name]; ok {
+			return fmt.Errorf("Duplicated template name %s", name)
+		}
+		if dogfile.Templates == nil {
+			dogfile.Templates = make(map[string]*taskYAML)
+		}
+		dogfile.Templates[name] = t
+	}
+	dogfile.Tasks = append(dogfile.Tasks, d.Tasks...)
+	return nil
+}
+
+// AddFunc adds a task that runs a Go function instead of code.
+//
+// The returned task can be modified to set its description, hooks,
+// environment or any other directive, and it takes part in task chains
+// like tasks defined in a Dogfile.
+func (dtasks *Dogtasks) AddFunc(name string, fn TaskFunc) (*Task, error) {
+	if _, ok := dtasks.Tasks[name]; ok {
+		return nil, fmt.Errorf("Duplicated task name %s", name)
+	}
+	if !validTaskName(name) {
+		return nil, fmt.Errorf("Invalid name for task %s", name)
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("Task %s has no function", name)
+	}
+
+	task := &Task{Name: name, Func: fn, Vars: dtasks.Vars, Env: dtasks.globalEnv()}
+	if dtasks.Tasks == nil {
+		dtasks.Tasks = make(map[string]*Task)
+	}
+	dtasks.Tasks[name] = task
+	return task, nil
+}
+
 // parseStringSlice takes an interface from a pre, post or env field
 // and returns a slice of strings representing the found values.
 func parseStringSlice(str interface{}) ([]string, error) {
 	switch h := str.(type) {
 	case string:
 		return []string{h}, nil
+	case []string:
+		return h, nil
 	case []interface{}:
 		s := make([]string, len(h))
 		for i, hook := range h {
@@ -136,6 +415,98 @@ func parseStringSlice(str interface{}) ([]string, error) {
 	}
 }
 
+// parseVars converts the global variables of a Dogfile into strings.
+// Values must be strings, numbers or booleans.
+func parseVars(vars map[string]interface{}) (map[string]string, error) {
+	if len(vars) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string)
+	for name, v := range vars {
+		if !validParamName(name) {
+			return nil, fmt.Errorf("Invalid name for variable %s", name)
+		}
+		switch v := v.(type) {
+		case string:
+			m[name] = v
+		case float64:
+			m[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			m[name] = strconv.FormatBool(v)
+		case nil:
+			m[name] = ""
+		default:
+			return nil, fmt.Errorf("Invalid value for variable %s", name)
+		}
+	}
+	return m, nil
+}
+
+// globalEnv returns the environment inherited by every task: global
+// variables sorted by name followed by global default values.
+func (dtasks *Dogtasks) globalEnv() []string {
+	var env []string
+	names := make([]string, 0, len(dtasks.Vars))
+	for name := range dtasks.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, fmt.Sprintf("%s=%s", name, dtasks.Vars[name]))
+	}
+	return append(env, dtasks.Env...)
+}
+
+// parseRunner converts a runner definition into a RunnerSpec.
+//
+// The command can be written as an array or as a single string, that is
+// split by white space.
+func parseRunner(parsedRunner *runnerYAML) (*RunnerSpec, error) {
+	var err error
+	spec := &RunnerSpec{Extension: parsedRunner.Extension}
+
+	if command, ok := parsedRunner.Command.(string); ok {
+		spec.Command = strings.Fields(command)
+	} else if spec.Command, err = parseStringSlice(parsedRunner.Command); err != nil {
+		return nil, err
+	}
+	if len(spec.Command) == 0 {
+		return nil, errors.New("No command specified for the runner")
+	}
+
+	if spec.Env, err = parseStringSlice(parsedRunner.Env); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// parseParams converts the params of a task into a slice of Param,
+// checking that each of them is well defined.
+func parseParams(params []*paramYAML) ([]Param, error) {
+	var ps []Param
+	names := make(map[string]bool)
+	for _, parsedParam := range params {
+		p := Param{
+			Name:     parsedParam.Name,
+			Required: parsedParam.Default == nil,
+			Choices:  parsedParam.Choices,
+			Regex:    parsedParam.Regex,
+		}
+		if parsedParam.Default != nil {
+			p.Default = *parsedParam.Default
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Duplicated parameter name %s", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.validateDefinition(); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
 // ParseFromDisk finds a Dogfile in disk and parses it.
 func ParseFromDisk(dir string) (dtasks Dogtasks, err error) {
 	if dir == "" {
@@ -154,42 +525,43 @@ func ParseFromDisk(dir string) (dtasks Dogtasks, err error) {
 		err = ErrNoDogfile
 		return
 	}
-	dtasks.Path, err = filepath.Abs(filepath.Dir(dtasks.Files[0]))
+	path, err := filepath.Abs(filepath.Dir(dtasks.Files[0]))
 	if err != nil {
 		return
 	}
+	return parseFiles(path, dtasks.Files)
+}
 
-	// iterate over every found file
-	for _, file := range dtasks.Files {
-		var fileData []byte
-		var d Dogtasks
+// parseFiles parses a group of Dogfiles found in the same directory,
+// which is the default working directory of their tasks.
+func parseFiles(dir string, files []string) (dtasks Dogtasks, err error) {
+	dtasks.Path = dir
+	dtasks.Files = append([]string{}, files...)
 
-		fileData, err = ioutil.ReadFile(file)
-		if err != nil {
+	// all the Dogfiles in the directory are parsed together
+	dogfiles := make([][]byte, len(files))
+	for i, file := range files {
+		if dogfiles[i], err = ioutil.ReadFile(file); err != nil {
 			return
 		}
+	}
+	d, err := parse(dir, dogfiles, files)
+	if err != nil {
+		return
+	}
+	dtasks.Vars, dtasks.Env, dtasks.Runners = d.Vars, d.Env, d.Runners
+	dtasks.Files = append(dtasks.Files, d.Files...)
 
-		// parse file
-		d, err = Parse(fileData)
-		if err != nil {
-			return
+	for _, t := range d.Tasks {
+		if dtasks.Tasks == nil {
+			dtasks.Tasks = make(map[string]*Task)
 		}
-
-		// add parsed tasks to main dogfile
-		for _, t := range d.Tasks {
-			if dtasks.Tasks == nil {
-				dtasks.Tasks = make(map[string]*Task)
-			}
-			if t.Workdir == "" {
-				t.Workdir = dtasks.Path
-			} else {
-				t.Workdir, err = filepath.Abs(t.Workdir)
-				if err != nil {
-					return
-				}
-			}
-			dtasks.Tasks[t.Name] = t
+		if t.Workdir == "" {
+			t.Workdir = dtasks.Path
+		} else if !filepath.IsAbs(t.Workdir) {
+			t.Workdir = filepath.Join(dtasks.Path, t.Workdir)
 		}
+		dtasks.Tasks[t.Name] = t
 	}
 
 	// validate resulting dogfile
@@ -200,21 +572,47 @@ func ParseFromDisk(dir string) (dtasks Dogtasks, err error) {
 
 // Validate checks that all tasks in a Dogfile are valid.
 //
-// It checks if any task has a non standard name and also if the
-// resulting task chain of each of them have an undesired cycle.
+// It checks if any task has a non standard name, a malformed parameter or
+// a runner that does not exist, if any hook references a task that does
+// not exist and also if the hooks of all tasks form an undesired cycle.
 func (dtasks *Dogtasks) Validate() error {
-	for _, t := range dtasks.Tasks {
+	names := dtasks.sortedNames()
+	for _, name := range names {
+		t := dtasks.Tasks[name]
 
-		if !validTaskName(t.Name) {
+		if !validTaskReference(t.Name) {
 			return fmt.Errorf("Invalid name for task %s", t.Name)
 		}
 
-		if _, err := NewTaskChain(*dtasks, t.Name); err != nil {
+		for _, p := range t.Params {
+			if err := p.validateDefinition(); err != nil {
+				return fmt.Errorf("Task %s: %v", t.Name, err)
+			}
+		}
+
+		registers := make(map[string]bool)
+		for _, r := range []string{t.Register, t.RegisterStderr, t.RegisterStatus} {
+			if r == "" {
+				continue
+			}
+			if registers[r] {
+				return fmt.Errorf("Task %s uses register %s more than once", t.Name, r)
+			}
+			registers[r] = true
+		}
+		if err := t.validateRegisterFormat(); err != nil {
 			return err
 		}
 
+		// runners defined in the Dogfile have a spec, others must
+		// be registered
+		if t.Func == nil && t.RunnerSpec == nil && t.Runner != "" {
+			if _, found := run.Lookup(t.Runner); !found {
+				return fmt.Errorf("Task %s: %s is not a supported runner", t.Name, t.Runner)
+			}
+		}
 	}
-	return nil
+	return dtasks.checkReferences(names)
 }
 
 // FindDogfiles finds Dogfiles in disk for a given path.
@@ -256,6 +654,43 @@ func FindDogfiles(p string) ([]string, error) {
 	}
 }
 
+// FindProjects finds the directories containing Dogfiles under root,
+// root included, walking down the directory tree. Hidden directories
+// are skipped.
+//
+// The returned paths are absolute and sorted.
+func FindProjects(root string) ([]string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []string
+	found := make(map[string]bool)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dir := filepath.Dir(p)
+		if validDogfileName(d.Name()) && !found[dir] {
+			found[dir] = true
+			projects = append(projects, dir)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
 // validDogfileName checks if a Dogfile name is valid as defined
 // by the Dogfile Spec.
 func validDogfileName(name string) bool {
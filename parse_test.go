@@ -1,7 +1,13 @@
 package dog
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -182,3 +188,676 @@ func TestDogfileValidatePostError(t *testing.T) {
 		t.Errorf("Failed, should have errored validating a Dogfile with an unexistent post task")
 	}
 }
+
+func TestDogfileParseParams(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: who-am-i
+  params:
+    - name: city
+    - name: planet
+      default: Earth
+    - name: animal
+      choices: [dog, cat]
+    - name: age
+      regex: ^\d+$
+  code: echo "$1 $2 $3 $4"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing params: %v", err)
+	}
+
+	want := []Param{
+		{Name: "city", Required: true},
+		{Name: "planet", Default: "Earth"},
+		{Name: "animal", Required: true, Choices: []string{"dog", "cat"}},
+		{Name: "age", Required: true, Regex: `^\d+$`},
+	}
+	if got := dtasks.Tasks["who-am-i"].Params; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v but was %v", want, got)
+	}
+}
+
+func TestDogfileParseParamsErrors(t *testing.T) {
+	for i, test := range []struct {
+		name   string
+		params string
+	}{
+		{"choices and regex", `
+    - name: animal
+      choices: [dog, cat]
+      regex: ^d`},
+		{"invalid regex", `
+    - name: age
+      regex: ^(\d+$`},
+		{"invalid name", `
+    - name: my-param`},
+		{"duplicated name", `
+    - name: city
+    - name: city`},
+		{"default not in choices", `
+    - name: animal
+      default: bird
+      choices: [dog, cat]`},
+	} {
+		if _, err := Parse([]byte(`
+- task: foo
+  code: echo foo
+  params:` + test.params)); err == nil {
+			t.Errorf("Test %d (%s): expected an error", i, test.name)
+		}
+	}
+}
+
+func TestDogfileValidateCycle(t *testing.T) {
+	dtasks := Dogtasks{
+		Tasks: map[string]*Task{
+			"foo":  {Name: "foo"},
+			"ping": {Name: "ping", Post: []string{"pong"}},
+			"pong": {Name: "pong", Post: []string{"ping"}},
+		},
+	}
+	err := dtasks.Validate()
+	if cycleErr, ok := err.(*CycleError); !ok {
+		t.Errorf("Expected a cycle error but was %v", err)
+	} else if got, want := cycleErr.Path, []string{"ping", "pong", "ping"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected cycle %v but was %v", want, got)
+	}
+}
+
+func TestDogfileParseRunners(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+runners:
+  deno:
+    command: [deno, run, -A]
+    extension: .ts
+    env: DENO_NO_UPDATE_CHECK=1
+
+tasks:
+  - task: named
+    runner: deno
+    code: console.log("named")
+
+  - task: inline
+    runner:
+      command: python3 -u
+      extension: .py
+    code: print("inline")
+
+  - task: registered
+    runner: bash
+    code: echo registered
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing runners: %v", err)
+	}
+
+	deno := &RunnerSpec{
+		Command:   []string{"deno", "run", "-A"},
+		Extension: ".ts",
+		Env:       []string{"DENO_NO_UPDATE_CHECK=1"},
+	}
+	if got := dtasks.Runners["deno"]; !reflect.DeepEqual(got, deno) {
+		t.Errorf("Expected runner %v but was %v", deno, got)
+	}
+	if got := dtasks.Tasks["named"].RunnerSpec; !reflect.DeepEqual(got, deno) {
+		t.Errorf("Expected named runner %v but was %v", deno, got)
+	}
+
+	inline := &RunnerSpec{Command: []string{"python3", "-u"}, Extension: ".py", Env: []string{}}
+	if got := dtasks.Tasks["inline"].RunnerSpec; !reflect.DeepEqual(got, inline) {
+		t.Errorf("Expected inline runner %v but was %v", inline, got)
+	}
+
+	if got := dtasks.Tasks["registered"]; got.Runner != "bash" || got.RunnerSpec != nil {
+		t.Errorf("Expected registered runner bash but was %v", got)
+	}
+}
+
+func TestDogfileParseRunnerWithoutCommand(t *testing.T) {
+	if _, err := Parse([]byte(`
+runners:
+  empty:
+    extension: .sh
+tasks:
+  - task: foo
+    code: echo foo
+`)); err == nil {
+		t.Errorf("Failed to detect a runner without command")
+	}
+}
+
+func TestDogfileParseUnknownRunner(t *testing.T) {
+	if _, err := Parse([]byte(`
+- task: foo
+  runner: blade
+  code: echo foo
+`)); err == nil {
+		t.Errorf("Failed to detect an unknown runner")
+	}
+}
+
+func TestParseFromDiskRunnersInOtherDogfile(t *testing.T) {
+	dir := t.TempDir()
+	writeDogfile(t, filepath.Join(dir, "dog.yml"), `
+runners:
+  shout:
+    command: [sh, -c, 'sh "$0" | tr a-z A-Z']
+tasks: []
+`)
+	writeDogfile(t, filepath.Join(dir, "dog-b.yml"), `
+- task: foo
+  runner: shout
+  code: echo foo
+`)
+
+	dtasks, err := ParseFromDisk(dir)
+	if err != nil {
+		t.Fatalf("Failed parsing a runner defined in another Dogfile: %v", err)
+	}
+	if dtasks.Tasks["foo"].RunnerSpec == nil {
+		t.Errorf("Expected task foo to use the runner defined in dog.yml")
+	}
+}
+
+func TestParseFromDiskExtendsInOtherDogfile(t *testing.T) {
+	dir := t.TempDir()
+	writeDogfile(t, filepath.Join(dir, "dog.yml"), `
+templates:
+  go:
+    runner: bash
+    env: CGO_ENABLED=0
+tasks: []
+`)
+	writeDogfile(t, filepath.Join(dir, "dog-b.yml"), `
+- task: build
+  extends: go
+  code: go build ./...
+`)
+
+	dtasks, err := ParseFromDisk(dir)
+	if err != nil {
+		t.Fatalf("Failed parsing a template defined in another Dogfile: %v", err)
+	}
+	build := dtasks.Tasks["build"]
+	if build.Runner != "bash" {
+		t.Errorf("Expected task build to inherit the bash runner but was %q", build.Runner)
+	}
+	if got, want := build.Env, []string{"CGO_ENABLED=0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected env %q but was %q", want, got)
+	}
+}
+
+func TestParseFromDiskGlobalsInOtherDogfile(t *testing.T) {
+	dir := t.TempDir()
+	writeDogfile(t, filepath.Join(dir, "dog.yml"), `
+vars:
+  SERVICE: api
+env:
+  - REGISTRY=registry.example.com
+tasks: []
+`)
+	writeDogfile(t, filepath.Join(dir, "dog-b.yml"), `
+- task: foo
+  description: Push {{.Vars.SERVICE}} to {{.Env.REGISTRY}}
+  code: echo foo
+`)
+
+	dtasks, err := ParseFromDisk(dir)
+	if err != nil {
+		t.Fatalf("Failed parsing globals defined in another Dogfile: %v", err)
+	}
+	foo := dtasks.Tasks["foo"]
+	if got, want := foo.Description, "Push api to registry.example.com"; got != want {
+		t.Errorf("Expected description %q but was %q", want, got)
+	}
+	if got, want := foo.Env, []string{"SERVICE=api", "REGISTRY=registry.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected env %q but was %q", want, got)
+	}
+}
+
+func TestDogfileParseShebang(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: shebang
+  code: |
+    #!/usr/bin/env python3
+    print("hello")
+
+- task: explicit
+  runner: bash
+  code: |
+    #!/usr/bin/env python3
+    echo "hello"
+
+- task: default
+  code: echo "hello"
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing Dogfile: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"shebang":  "auto",
+		"explicit": "bash",
+		"default":  "sh",
+	} {
+		if got := dtasks.Tasks[name].Runner; got != want {
+			t.Errorf("Task %s: expected runner %s but was %s", name, want, got)
+		}
+	}
+}
+
+func TestDogfileParseRegisters(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: echo foo
+  register: FOO_OUT
+  register_stderr: FOO_ERR
+  register_status: FOO_STATUS
+  ignore_errors: true
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing registers: %v", err)
+	}
+	foo := dtasks.Tasks["foo"]
+	if foo.Register != "FOO_OUT" || foo.RegisterStderr != "FOO_ERR" || foo.RegisterStatus != "FOO_STATUS" {
+		t.Errorf("Unexpected registers %q, %q and %q", foo.Register, foo.RegisterStderr, foo.RegisterStatus)
+	}
+	if !foo.IgnoreErrors {
+		t.Errorf("Expected task foo to ignore errors")
+	}
+
+	if _, err = Parse([]byte(`
+- task: foo
+  code: echo foo
+  register: FOO
+  register_status: FOO
+`)); err == nil {
+		t.Errorf("Expected an error for a register used twice")
+	}
+}
+
+func TestDogfileParseRegisterFormat(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+- task: foo
+  code: echo '{}'
+  register: FOO
+  register_json: .items[0]
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing a JSON register: %v", err)
+	}
+	if got := dtasks.Tasks["foo"].RegisterFormat; got != RegisterJSON {
+		t.Errorf("Expected format %s but was %s", RegisterJSON, got)
+	}
+
+	for i, test := range []struct {
+		name      string
+		directive string
+	}{
+		{"unknown format", `
+  register: FOO
+  register_format: xml`},
+		{"format without register", `
+  register_format: json`},
+		{"path in a text register", `
+  register: FOO
+  register_format: text
+  register_json: .version`},
+		{"invalid path", `
+  register: FOO
+  register_json: version`},
+	} {
+		if _, err := Parse([]byte(`
+- task: foo
+  code: echo foo` + test.directive)); err == nil {
+			t.Errorf("Test %d (%s): expected an error", i, test.name)
+		}
+	}
+}
+
+func TestDogfileParseGlobals(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+vars:
+  SERVICE: api
+  PORT: 8080
+  DEBUG: false
+env: REGISTRY=example.com
+tasks:
+  - task: foo
+    env: PORT=9090
+    code: echo foo
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing globals: %v", err)
+	}
+	if got, want := dtasks.Vars, map[string]string{"SERVICE": "api", "PORT": "8080", "DEBUG": "false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected vars %v but was %v", want, got)
+	}
+	want := []string{"DEBUG=false", "PORT=8080", "SERVICE=api", "REGISTRY=example.com", "PORT=9090"}
+	if got := dtasks.Tasks["foo"].Env; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected env %v but was %v", want, got)
+	}
+
+	for _, vars := range []string{"{my-var: foo}", "{FOO: [a, b]}"} {
+		if _, err := Parse([]byte("vars: " + vars + "\ntasks: []")); err == nil {
+			t.Errorf("Expected an error for vars %s", vars)
+		}
+	}
+}
+
+func TestDogfileParseExtends(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+templates:
+  go:
+    runner: bash
+    workdir: src
+    pre: fmt
+    env: [CGO_ENABLED=0, GOOS=linux]
+    code: go build ./...
+tasks:
+  - task: fmt
+    code: gofmt -l .
+  - task: build
+    extends: go
+  - task: build-darwin
+    extends: build
+    env: GOOS=darwin
+  - task: test
+    extends: go
+    pre: []
+    env: [GOFLAGS=-race]
+    code: go test ./...
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing tasks extending a template: %v", err)
+	}
+	if _, ok := dtasks.Tasks["go"]; ok {
+		t.Errorf("Expected templates not to be tasks")
+	}
+
+	darwin := dtasks.Tasks["build-darwin"]
+	if darwin.Runner != "bash" || darwin.Workdir != "src" || darwin.Code != "go build ./..." {
+		t.Errorf("Unexpected runner %s, workdir %s or code %q", darwin.Runner, darwin.Workdir, darwin.Code)
+	}
+	if want := []string{"fmt"}; !reflect.DeepEqual(darwin.Pre, want) {
+		t.Errorf("Expected pre-hooks %v but was %v", want, darwin.Pre)
+	}
+	if want := []string{"CGO_ENABLED=0", "GOOS=darwin"}; !reflect.DeepEqual(darwin.Env, want) {
+		t.Errorf("Expected env %v but was %v", want, darwin.Env)
+	}
+
+	test := dtasks.Tasks["test"]
+	if len(test.Pre) != 0 || test.Code != "go test ./..." {
+		t.Errorf("Expected pre-hooks and code to be overridden but were %v and %q", test.Pre, test.Code)
+	}
+	if want := []string{"CGO_ENABLED=0", "GOOS=linux", "GOFLAGS=-race"}; !reflect.DeepEqual(test.Env, want) {
+		t.Errorf("Expected env %v but was %v", want, test.Env)
+	}
+
+	for i, test := range []struct {
+		name    string
+		dogfile string
+	}{
+		{"unknown base", "- {task: foo, extends: bar}"},
+		{"cycle", "- {task: foo, extends: bar}\n- {task: bar, extends: baz}\n- {task: baz, extends: foo}"},
+		{"extends itself", "- {task: foo, extends: foo}"},
+		{"template named as a task", "templates: {foo: {code: echo}}\ntasks: [{task: foo, extends: foo}]"},
+		{"invalid template name", "templates: {foo bar: {code: echo}}\ntasks: []"},
+		{"template run as hook", "templates: {foo: {code: echo}}\ntasks: [{task: bar, pre: foo}]"},
+	} {
+		if _, err := Parse([]byte(test.dogfile)); err == nil {
+			t.Errorf("Test %d (%s): expected an error", i, test.name)
+		}
+	}
+}
+
+func TestDogfileParseInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeDogfile(t, filepath.Join(dir, "shared", "dog.yml"), `
+include: {path: ../tools, as: tools}
+tasks:
+  - task: build
+    pre: tools:lint
+    code: echo build
+  - task: clean
+    workdir: out
+    code: echo clean
+`)
+	writeDogfile(t, filepath.Join(dir, "tools", "dog-tools.yml"), `
+- task: lint
+  code: echo lint
+`)
+	writeDogfile(t, filepath.Join(dir, "service", "dog.yml"), `
+include:
+  - path: ../shared/dog.yml
+    as: shared
+tasks:
+  - task: test
+    pre: shared:build
+    post: shared:clean
+    code: echo test
+`)
+
+	dtasks, err := ParseFromDisk(filepath.Join(dir, "service"))
+	if err != nil {
+		t.Fatalf("Failed parsing a Dogfile with includes: %v", err)
+	}
+
+	taskChain, err := NewTaskChain(dtasks, "test")
+	if err != nil {
+		t.Fatalf("Failed generating a task chain: %v", err)
+	}
+	var names []string
+	for _, task := range taskChain.Tasks {
+		names = append(names, task.Name)
+	}
+	if want := []string{"shared:tools:lint", "shared:build", "test", "shared:clean"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected task chain %v but was %v", want, names)
+	}
+
+	workdirs := map[string]string{
+		"test":              filepath.Join(dir, "service"),
+		"shared:build":      filepath.Join(dir, "shared"),
+		"shared:clean":      filepath.Join(dir, "shared", "out"),
+		"shared:tools:lint": filepath.Join(dir, "tools"),
+	}
+	for name, want := range workdirs {
+		if got := dtasks.Tasks[name].Workdir; got != want {
+			t.Errorf("Expected workdir %s for task %s but was %s", want, name, got)
+		}
+	}
+	if got := len(dtasks.Files); got != 3 {
+		t.Errorf("Expected 3 Dogfiles but found %v", dtasks.Files)
+	}
+}
+
+func TestDogfileParseIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeDogfile(t, filepath.Join(dir, "a", "dog.yml"), `
+include: {path: ../b, as: b}
+tasks: []
+`)
+	writeDogfile(t, filepath.Join(dir, "b", "dog.yml"), `
+include: {path: ../a/dog.yml, as: a}
+tasks: []
+`)
+	if _, err := ParseFromDisk(filepath.Join(dir, "a")); !errors.Is(err, ErrIncludeCycle) {
+		t.Errorf("Expected an include cycle but was %v", err)
+	}
+
+	for i, test := range []struct {
+		name    string
+		dogfile string
+	}{
+		{"missing path", "include: {path: missing.yml, as: missing}\ntasks: []"},
+		{"invalid namespace", "include: {path: ../a/dog.yml, as: a:b}\ntasks: []"},
+		{"unknown task", "include: {path: ../c/dog.yml, as: c}\ntasks: [{task: foo, pre: c:bar, code: echo}]"},
+	} {
+		writeDogfile(t, filepath.Join(dir, "c", "dog.yml"), "- task: foo\n  code: echo foo")
+		writeDogfile(t, filepath.Join(dir, "d", "dog.yml"), test.dogfile)
+		if _, err := ParseFromDisk(filepath.Join(dir, "d")); err == nil {
+			t.Errorf("Test %d (%s): expected an error", i, test.name)
+		}
+	}
+}
+
+func TestDogfileParseTemplates(t *testing.T) {
+	t.Setenv("DOG_SYSTEM", "system")
+
+	dtasks, err := Parse([]byte(`
+vars:
+  SERVICE: api
+env: [REGISTRY=example.com, DOG_SYSTEM=dogfile]
+tasks:
+  - task: build
+    description: Build {{ .Vars.SERVICE }} for {{ os }}/{{ arch }}
+    workdir: "{{ .Vars.SERVICE }}/app"
+    pre: "{{ .Vars.SERVICE }}-deps"
+    env: IMAGE={{ .Env.REGISTRY }}/{{ .Params.tag }}
+    code: echo '{{ not a template }}'
+  - task: api-deps
+    description: Uses {{ .Env.DOG_SYSTEM }}
+    code: echo deps
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing templates: %v", err)
+	}
+	build := dtasks.Tasks["build"]
+	if want := fmt.Sprintf("Build api for %s/%s", runtime.GOOS, runtime.GOARCH); build.Description != want {
+		t.Errorf("Expected description %q but was %q", want, build.Description)
+	}
+	if build.Workdir != "api/app" {
+		t.Errorf("Expected workdir api/app but was %s", build.Workdir)
+	}
+	if want := []string{"api-deps"}; !reflect.DeepEqual(build.Pre, want) {
+		t.Errorf("Expected pre-hooks %v but was %v", want, build.Pre)
+	}
+	if got := build.Env[len(build.Env)-1]; got != "IMAGE={{ .Env.REGISTRY }}/{{ .Params.tag }}" {
+		t.Errorf("Expected templates in env to be executed when the task runs but was %s", got)
+	}
+	if got := build.Code; got != "echo '{{ not a template }}'" {
+		t.Errorf("Expected code not to be a template but was %s", got)
+	}
+	if got := dtasks.Tasks["api-deps"].Description; got != "Uses system" {
+		t.Errorf("Expected system variables to take precedence but was %q", got)
+	}
+
+	for i, test := range []struct {
+		name  string
+		field string
+	}{
+		{"undefined variable", "description: '{{ .Vars.MISSING }}'"},
+		{"params in hooks", "pre: '{{ .Params.name }}'"},
+		{"params in description", "description: '{{ if .Vars.SERVICE }}{{ $.Params.name }}{{ end }}'"},
+		{"unknown function", "workdir: '{{ lower .Vars.SERVICE }}'"},
+		{"syntax error in env", "env: FOO={{ .Vars.SERVICE"},
+	} {
+		_, err := Parse([]byte("vars: {SERVICE: api}\ntasks:\n  - task: foo\n    code: echo foo\n    " + test.field))
+		if err == nil || !strings.Contains(err.Error(), "Task foo") {
+			t.Errorf("Test %d (%s): expected an error for task foo but was %v", i, test.name, err)
+		}
+		if err != nil && strings.Contains(test.name, "params") && strings.Contains(err.Error(), "map has no entry") {
+			t.Errorf("Test %d (%s): expected an error explaining where params can be used but was %v", i, test.name, err)
+		}
+	}
+}
+
+func TestDogfileParseTemplateEscapes(t *testing.T) {
+	dtasks, err := Parse([]byte(`
+vars:
+  SERVICE: api
+env:
+  - IMAGE=registry.example.com/{{ .Vars.SERVICE }}
+  - TAG={{ .Env.IMAGE }}:latest
+tasks:
+  - task: ps
+    description: 'Lists {{ .Env.TAG }} containers with {{ "{{.ID}}" }}'
+    env: 'FORMAT={{ "{{" }}.Names}}'
+    code: docker ps --format "$FORMAT"
+  - task: inspect
+    description: '{{` + "`{{.State}}`" + `}} of {{ .Vars.SERVICE }}'
+    code: docker inspect api
+`))
+	if err != nil {
+		t.Fatalf("Failed parsing escaped templates: %v", err)
+	}
+	if got, want := dtasks.Tasks["ps"].Description, "Lists registry.example.com/api:latest containers with {{.ID}}"; got != want {
+		t.Errorf("Expected description %q but was %q", want, got)
+	}
+	if got, want := dtasks.Tasks["inspect"].Description, "{{.State}} of api"; got != want {
+		t.Errorf("Expected description %q but was %q", want, got)
+	}
+
+	env, _, err := dtasks.Tasks["ps"].runtimeFields(nil)
+	if err != nil {
+		t.Fatalf("Failed executing env templates: %v", err)
+	}
+	if got, want := env[len(env)-1], "FORMAT={{.Names}}"; got != want {
+		t.Errorf("Expected env %q but was %q", want, got)
+	}
+
+	_, err = Parse([]byte("env: FOO={{ .Vars.MISSING }}\ntasks: []"))
+	if err == nil || !strings.Contains(err.Error(), "Global env") {
+		t.Errorf("Expected an error in the global env but was %v", err)
+	}
+}
+
+func TestGitSHA(t *testing.T) {
+	dir := t.TempDir()
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	writeDogfile(t, filepath.Join(dir, ".git", "HEAD"), "ref: refs/heads/main\n")
+	writeDogfile(t, filepath.Join(dir, ".git", "packed-refs"), "# pack-refs with: peeled\n"+sha+" refs/heads/main\n")
+	writeDogfile(t, filepath.Join(dir, "service", "dog.yml"), "- task: foo\n  description: '{{ gitsha }}'\n  code: echo foo")
+
+	dtasks, err := ParseFromDisk(filepath.Join(dir, "service"))
+	if err != nil {
+		t.Fatalf("Failed parsing a Dogfile using gitsha: %v", err)
+	}
+	if got := dtasks.Tasks["foo"].Description; got != sha {
+		t.Errorf("Expected the packed commit %s but was %s", sha, got)
+	}
+
+	sha = "89abcdef0123456789abcdef0123456789abcdef"
+	writeDogfile(t, filepath.Join(dir, ".git", "refs", "heads", "main"), sha+"\n")
+	if got, err := gitSHA(filepath.Join(dir, "service")); err != nil || got != sha {
+		t.Errorf("Expected the loose commit %s but was %s (%v)", sha, got, err)
+	}
+
+	if _, err := gitSHA(t.TempDir()); err == nil {
+		t.Errorf("Expected an error outside of a git repository")
+	}
+}
+
+func writeDogfile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindProjects(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{
+		"dog.yml",
+		"services/api/dog.yml",
+		"services/api/dog-extra.yml",
+		"services/web/dog.yaml",
+		"services/web/README.md",
+		"docs/index.md",
+		".git/dog.yml",
+	} {
+		writeDogfile(t, filepath.Join(dir, p), "- task: foo\n  code: echo foo")
+	}
+
+	projects, err := FindProjects(dir)
+	if err != nil {
+		t.Fatalf("Failed finding projects: %v", err)
+	}
+	want := []string{dir, filepath.Join(dir, "services", "api"), filepath.Join(dir, "services", "web")}
+	if !reflect.DeepEqual(projects, want) {
+		t.Errorf("Expected projects %v but found %v", want, projects)
+	}
+}
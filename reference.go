@@ -0,0 +1,155 @@
+package dog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootDogfile is the name of the Dogfile that marks the root directory
+// of a workspace.
+const RootDogfile = "dog-root.yml"
+
+// isPathReference reports whether a task reference includes the directory
+// of the Dogfile defining the task, as in ../lib:build or //tools:generate.
+func isPathReference(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "/")
+}
+
+// resolvePathReference converts a reference to a task in another directory
+// into its absolute form, /path/to/dir:task. Relative paths are relative to
+// dir, and paths starting with // are relative to the workspace root.
+//
+// References to tasks in dir itself are returned as plain task names.
+func resolvePathReference(ref, dir string) (string, error) {
+	i := strings.Index(ref, NamespaceSeparator)
+	if i < 0 || !validTaskReference(ref[i+1:]) {
+		return "", fmt.Errorf("Invalid task reference %s", ref)
+	}
+	p, name := ref[:i], ref[i+1:]
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(p, "//"):
+		root, err := FindRoot(dir)
+		if err != nil {
+			return "", fmt.Errorf("Task reference %s: %w", ref, err)
+		}
+		p = filepath.Join(root, p[2:])
+	case !filepath.IsAbs(p):
+		p = filepath.Join(dir, p)
+	}
+
+	if p == dir {
+		return name, nil
+	}
+	return p + NamespaceSeparator + name, nil
+}
+
+// FindRoot returns the root directory of the workspace containing dir: the
+// closest directory with a dog-root.yml file or, if there is none, the
+// topmost directory containing Dogfiles.
+func FindRoot(dir string) (string, error) {
+	current, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var topmost string
+	for {
+		files, err := ioutil.ReadDir(current)
+		if err != nil && !os.IsPermission(err) {
+			return "", err
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			if file.Name() == RootDogfile {
+				return current, nil
+			}
+			if validDogfileName(file.Name()) {
+				topmost = current
+			}
+		}
+
+		next := filepath.Dir(current)
+		if next == current {
+			break
+		}
+		current = next
+	}
+
+	if topmost == "" {
+		return "", ErrNoDogfile
+	}
+	return topmost, nil
+}
+
+// loadReferences parses the Dogfiles defining the tasks in other
+// directories that can be reached from task through pre and post hooks,
+// adding their tasks to dtasks under their absolute references.
+//
+// The map of tasks is copied before adding tasks to it, so the Dogtasks
+// passed to NewTaskChain are never modified.
+func (dtasks *Dogtasks) loadReferences(task string) error {
+	copied := false
+	seen := make(map[string]bool)
+	pending := []string{task}
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		t, found := dtasks.Tasks[name]
+		if !found && isPathReference(name) {
+			if !copied {
+				tasks := make(map[string]*Task, len(dtasks.Tasks))
+				for k, v := range dtasks.Tasks {
+					tasks[k] = v
+				}
+				dtasks.Tasks = tasks
+				copied = true
+			}
+			dir := name[:strings.Index(name, NamespaceSeparator)]
+			if err := dtasks.loadDir(dir); err != nil {
+				return fmt.Errorf("Task %q: %w", name, err)
+			}
+			t, found = dtasks.Tasks[name]
+		}
+		if found {
+			pending = append(pending, t.Pre...)
+			pending = append(pending, t.Post...)
+		}
+	}
+	dtasks.loaded = true
+	return nil
+}
+
+// loadDir parses the Dogfiles in dir and adds their tasks to dtasks, named
+// after the directory.
+func (dtasks *Dogtasks) loadDir(dir string) error {
+	files, err := includedFiles(dir)
+	if err != nil {
+		return err
+	}
+	d, err := parseFiles(dir, files)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range d.sortedNames() {
+		t := *d.Tasks[name]
+		t.qualify(dir)
+		dtasks.Tasks[t.Name] = &t
+	}
+	return nil
+}
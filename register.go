@@ -0,0 +1,205 @@
+package dog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formats of the output stored in registers.
+const (
+	// RegisterText stores the output as it is.
+	RegisterText = "text"
+
+	// RegisterJSON parses the output as JSON and stores each of the
+	// top-level fields of the selected value in its own register.
+	RegisterJSON = "json"
+)
+
+// validateRegisterFormat checks that the format of the register of a task
+// and the path selecting its value are valid.
+func (t Task) validateRegisterFormat() error {
+	switch t.RegisterFormat {
+	case "", RegisterText:
+		if t.RegisterPath != "" {
+			return fmt.Errorf("Task %s selects a JSON path from a %s register", t.Name, RegisterText)
+		}
+	case RegisterJSON:
+		if t.Register == "" {
+			return fmt.Errorf("Task %s uses a %s format without a register", t.Name, RegisterJSON)
+		}
+		if _, err := parseJSONPath(t.RegisterPath); err != nil {
+			return fmt.Errorf("Task %s: %v", t.Name, err)
+		}
+	default:
+		return fmt.Errorf("Task %s uses unknown register format %s", t.Name, t.RegisterFormat)
+	}
+	return nil
+}
+
+// storeRegisters saves the outputs and exit status of a task in res,
+// returning an error if they don't match the format of the register.
+func storeRegisters(t Task, res *TaskResult, out, errOut *bytes.Buffer) error {
+	registers := make(map[string]string)
+	if t.Register != "" {
+		value := registerValue(t, out)
+		if t.RegisterFormat == RegisterJSON {
+			if err := jsonRegisters(t, value, registers); err != nil {
+				return err
+			}
+			value = registers[t.Register]
+		} else {
+			registers[t.Register] = value
+		}
+		res.Register = value
+	}
+	if t.RegisterStderr != "" {
+		registers[t.RegisterStderr] = registerValue(t, errOut)
+	}
+	if t.RegisterStatus != "" {
+		status := res.ExitStatus
+		if res.Signal != 0 {
+			status = 128 + int(res.Signal)
+		}
+		registers[t.RegisterStatus] = strconv.Itoa(status)
+	}
+	res.Registers = registers
+	return nil
+}
+
+// registerValue returns the value stored in a register from the output
+// written to buf by task t.
+func registerValue(t Task, buf *bytes.Buffer) string {
+	value := buf.String()
+	if t.TTY {
+		value = stripTerminal(value)
+	}
+	return strings.TrimSpace(value)
+}
+
+// jsonRegisters parses the output of a task as JSON and stores the value
+// found in the path of the register, and each of its top-level fields when
+// it is an object, named after the register and the field.
+func jsonRegisters(t Task, output string, registers map[string]string) error {
+	d := json.NewDecoder(strings.NewReader(output))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("Task %q registered invalid JSON: %v", t.Name, err)
+	}
+	if d.More() {
+		return fmt.Errorf("Task %q registered invalid JSON: more than one value", t.Name)
+	}
+
+	path, err := parseJSONPath(t.RegisterPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range path {
+		var found bool
+		switch key := p.(type) {
+		case string:
+			var obj map[string]interface{}
+			if obj, found = v.(map[string]interface{}); found {
+				v, found = obj[key]
+			}
+		case int:
+			var arr []interface{}
+			if arr, found = v.([]interface{}); found && key < len(arr) {
+				v = arr[key]
+			} else {
+				found = false
+			}
+		}
+		if !found {
+			return fmt.Errorf("Task %q registered JSON without %s", t.Name, t.RegisterPath)
+		}
+	}
+
+	registers[t.Register] = jsonString(v)
+	if obj, ok := v.(map[string]interface{}); ok {
+		for k, field := range obj {
+			registers[t.Register+"_"+envName(k)] = jsonString(field)
+		}
+	}
+	return nil
+}
+
+// jsonPathElement matches an element of a JSON path: a field name
+// preceded by a dot or an array index between brackets.
+var jsonPathElement = regexp.MustCompile(`^(?:\.([^.\[\]]+)|\[(\d+)\])`)
+
+// parseJSONPath splits a path like .items[0].name into field names and
+// array indexes. Empty paths and "." select the whole value.
+func parseJSONPath(path string) ([]interface{}, error) {
+	var elements []interface{}
+	rest := path
+	if rest == "." {
+		rest = ""
+	}
+	for rest != "" {
+		m := jsonPathElement.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("Invalid JSON path %s", path)
+		}
+		if m[1] != "" {
+			elements = append(elements, m[1])
+		} else {
+			i, err := strconv.Atoi(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid JSON path %s", path)
+			}
+			elements = append(elements, i)
+		}
+		rest = rest[len(m[0]):]
+	}
+	return elements, nil
+}
+
+// jsonString returns the value stored in a register for a JSON value:
+// strings without quotes, nothing for null and JSON for anything else.
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// envName converts a JSON field name into the suffix of an environment
+// variable name, in upper case and with underscores replacing the
+// characters not allowed in names.
+func envName(field string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, field)
+}
+
+// terminalEscapes matches the escape sequences written by programs
+// running in a terminal, and the carriage returns it adds to new lines.
+var terminalEscapes = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]|\r`)
+
+// stripTerminal removes terminal escape sequences from the output of
+// a task.
+func stripTerminal(s string) string {
+	return terminalEscapes.ReplaceAllString(s, "")
+}
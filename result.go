@@ -0,0 +1,101 @@
+package dog
+
+import (
+	"syscall"
+	"time"
+)
+
+// TaskState describes how a task ended in a task chain execution.
+type TaskState int
+
+const (
+	// TaskSkipped means that the task did not run, usually because a
+	// previous task in the chain failed.
+	TaskSkipped TaskState = iota
+
+	// TaskSucceeded means that the task finished with a zero exit status.
+	TaskSucceeded
+
+	// TaskFailed means that the task could not run or finished with an
+	// error.
+	TaskFailed
+)
+
+func (s TaskState) String() string {
+	switch s {
+	case TaskSkipped:
+		return "skipped"
+	case TaskSucceeded:
+		return "succeeded"
+	case TaskFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// TaskResult holds information about the execution of a task.
+type TaskResult struct {
+	// Name of the task.
+	Name string
+
+	// State of the task when the chain execution finished.
+	State TaskState
+
+	// Start and End are the times when the task started and finished
+	// running. They are zero for skipped tasks.
+	Start time.Time
+	End   time.Time
+
+	// ExitStatus is the exit status of the task runner, or -1 if the
+	// runner was terminated by a signal.
+	ExitStatus int
+
+	// Signal is the signal that terminated the runner, if any.
+	Signal syscall.Signal
+
+	// Register is the value stored by the task when it defines a register.
+	Register string
+
+	// Registers holds every value stored by the task, including the
+	// output, standard error and exit status registers, mapped by
+	// register name.
+	Registers map[string]string
+
+	// Err is the error returned by the task, if any. Tasks ignoring errors
+	// succeed even if Err is not nil.
+	Err error
+}
+
+// Duration returns the time spent running the task.
+func (r TaskResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// ChainResult holds information about the execution of a task chain.
+type ChainResult struct {
+	// Tasks contains a result for every task in the chain, in the same
+	// order as they appear in the chain.
+	Tasks []TaskResult
+
+	// Start and End are the times when the chain execution started
+	// and finished.
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the time spent running the task chain.
+func (r *ChainResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Failed returns the result of the task that made the chain fail, or nil
+// if no task failed.
+func (r *ChainResult) Failed() *TaskResult {
+	for i := range r.Tasks {
+		if r.Tasks[i].State == TaskFailed {
+			return &r.Tasks[i]
+		}
+	}
+	return nil
+}
@@ -3,54 +3,201 @@ package run
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 )
 
 // runCmd embeds and extends exec.Cmd.
 type runCmd struct {
 	exec.Cmd
+	tmpDir  string
 	tmpFile string
+
+	// props holds the code and arguments, written to the temporary file
+	// and added to the command when it starts.
+	props runCmdProperties
+
+	// terminal is the file descriptor of the terminal handed over to
+	// the command, or -1 when it runs in the background.
+	terminal int
+
+	// background keeps the command in the background of the terminal.
+	background bool
+
+	// term is set when the command runs in a pseudo-terminal.
+	term *terminalIO
+
+	// signaled is set once a signal is sent to the command, so Wait
+	// also waits for the rest of its process group to exit.
+	signaled atomic.Bool
+
+	// pipes are the write ends of the output pipes, closed in dog once
+	// the command starts.
+	pipes []*os.File
 }
 
 // runCmdProperties defines how a new runCmd needs to be created.
 type runCmdProperties struct {
-	runner        string
+	command       []string
 	fileExtension string
 	code          string
 	workdir       string
 	env           []string
+	args          []string
+
+	// executable makes the temporary file executable and runs it directly
+	// instead of passing it to a command, for code that includes its own
+	// shebang line.
+	executable bool
+}
+
+// StdoutPipe returns a pipe connected to the standard output of the
+// command, or to its pseudo-terminal if it uses one.
+//
+// Unlike the pipes of exec.Cmd, the pipe isn't closed by Wait, so the
+// output can be read after the command exits. It must be closed by the
+// caller.
+func (c *runCmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.term != nil {
+		return c.term.reader(), nil
+	}
+	return c.outputPipe(&c.Stdout)
+}
+
+// StderrPipe returns a pipe connected to the standard error of the
+// command. Commands using a pseudo-terminal write their errors to the
+// standard output, so the pipe returned for them is always empty.
+func (c *runCmd) StderrPipe() (io.ReadCloser, error) {
+	if c.term != nil {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return c.outputPipe(&c.Stderr)
+}
+
+// outputPipe connects an output of the command to a new pipe. The write
+// end is closed once the command starts, so reads get EOF when every
+// process sharing it has exited.
+func (c *runCmd) outputPipe(w *io.Writer) (io.ReadCloser, error) {
+	if *w != nil {
+		return nil, errors.New("Output already set")
+	}
+	if c.Process != nil {
+		return nil, errors.New("Runner already started")
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	*w = pw
+	c.pipes = append(c.pipes, pw)
+	return pr, nil
+}
+
+// closePipes closes the write ends of the output pipes in dog.
+func (c *runCmd) closePipes() {
+	for _, pw := range c.pipes {
+		pw.Close()
+	}
+	c.pipes = nil
+}
+
+// KeepBackground keeps the command in the background of the terminal
+// instead of moving it to the foreground when it starts.
+func (c *runCmd) KeepBackground() {
+	c.background = true
+}
+
+// Start starts the command but does not wait for it to complete.
+//
+// This method overrides the Start method that comes from the embedded
+// exec.Cmd type, moving the command to the foreground of the terminal
+// where the platform supports it.
+func (c *runCmd) Start() error {
+	if err := c.writeTempFile(c.props.code, c.props.fileExtension, c.props.executable); err != nil {
+		c.closePipes()
+		if c.term != nil {
+			c.closeTerminal()
+		}
+		c.removeTempDir()
+		return err
+	}
+	if c.props.executable {
+		c.Path = c.tmpFile
+	}
+	c.Args = append(c.Args, c.tmpFile)
+	c.Args = append(c.Args, c.props.args...)
+
+	if c.term != nil {
+		err := c.startTerminal()
+		if err != nil {
+			c.removeTempDir()
+		}
+		return err
+	}
+
+	c.takeForeground()
+	err := c.Cmd.Start()
+	c.closePipes()
+	if err != nil {
+		c.releaseTerminal()
+		c.removeTempDir()
+		return err
+	}
+	return nil
 }
 
 // Wait waits until the command finishes running and provides exit information.
 //
 // This method overrites the Wait method that comes from the embedded exec.Cmd
-// type, adding the removal of the temporary file.
+// type, adding the removal of the temporary directory and giving back the
+// terminal to dog if the command was using it. When the command has been
+// signaled, it also waits for the other processes in its group to exit.
 func (c *runCmd) Wait() error {
-	defer func() {
-		_ = os.Remove(c.tmpFile)
-	}()
+	defer c.removeTempDir()
 
 	err := c.Cmd.Wait()
+	if c.signaled.Load() {
+		c.waitProcessGroup()
+	}
+	if c.term != nil {
+		c.closeTerminal()
+	}
+	c.releaseTerminal()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// removeTempDir removes the temporary directory holding the code.
+func (c *runCmd) removeTempDir() {
+	if c.tmpDir != "" {
+		_ = os.RemoveAll(c.tmpDir)
+	}
+}
+
 // writeTempFile copies the code in a temporary file that will get passed as an
-// argument to the runner (as in `sh <tmpFile>`).
-func (c *runCmd) writeTempFile(data string, fileExtension string) error {
+// argument to the runner (as in `sh <tmpFile>`), or run directly when it is
+// executable. The file is only created when the command starts, so runners
+// that are never started leave nothing behind.
+func (c *runCmd) writeTempFile(data string, fileExtension string, executable bool) error {
 	dir, err := ioutil.TempDir("", "dog")
 	if err != nil {
 		return err
 	}
 
+	c.tmpDir = dir
 	c.tmpFile = fmt.Sprintf("%s/task%s", dir, fileExtension)
 
-	err = ioutil.WriteFile(c.tmpFile, []byte(data), 0644)
+	var perm os.FileMode = 0644
+	if executable {
+		perm = 0755
+	}
+	err = ioutil.WriteFile(c.tmpFile, []byte(data), perm)
 	if err != nil {
 		return err
 	}
@@ -63,23 +210,25 @@ func newCmdRunner(p runCmdProperties) (Runner, error) {
 		return nil, errors.New("No code specified to run")
 	}
 
-	cmd := runCmd{}
+	cmd := runCmd{terminal: -1, props: p}
 
-	path, err := exec.LookPath(strings.Fields(p.runner)[0])
-	if err != nil {
-		return nil, err
-	}
-	cmd.Path = path
-	cmd.Args = append(cmd.Args, strings.Fields(p.runner)...)
-	err = cmd.writeTempFile(p.code, p.fileExtension)
-	if err != nil {
-		return nil, err
+	// executable files are run directly once they are written
+	if !p.executable {
+		if len(p.command) == 0 {
+			return nil, errors.New("No command specified for the runner")
+		}
+		path, err := exec.LookPath(p.command[0])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Path = path
+		cmd.Args = append(cmd.Args, p.command...)
 	}
-	cmd.Args = append(cmd.Args, cmd.tmpFile)
 	cmd.Dir = p.workdir
 	cmd.Env = append(cmd.Env, os.Environ()...)
 	cmd.Env = append(cmd.Env, p.env...)
 	cmd.Stdin = os.Stdin
+	cmd.newProcessGroup()
 
 	return &cmd, nil
 }
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package run
+
+import (
+	"errors"
+	"os"
+)
+
+// newProcessGroup does nothing, as process groups are not supported on
+// this platform.
+func (c *runCmd) newProcessGroup() {}
+
+// Signal kills the command, as it is the only signal that can be sent on
+// this platform. Processes started by the command are not stopped.
+func (c *runCmd) Signal(sig os.Signal) error {
+	if c.Process == nil {
+		return errors.New("Runner not started")
+	}
+	c.signaled.Store(true)
+	return c.Process.Kill()
+}
+
+func (c *runCmd) waitProcessGroup() {}
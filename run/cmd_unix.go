@@ -0,0 +1,45 @@
+//go:build unix
+
+package run
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// groupExitTimeout is the maximum amount of time that Wait spends waiting
+// for the process group of a signaled command after the command exits.
+const groupExitTimeout = 10 * time.Second
+
+// newProcessGroup makes the command run in its own process group, so it
+// can be signaled together with its children.
+func (c *runCmd) newProcessGroup() {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Signal sends a signal to the process group of the command.
+func (c *runCmd) Signal(sig os.Signal) error {
+	if c.Process == nil {
+		return errors.New("Runner not started")
+	}
+	c.signaled.Store(true)
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return c.Process.Signal(sig)
+	}
+	return syscall.Kill(-c.Process.Pid, s)
+}
+
+// waitProcessGroup waits until no process is left in the process group of
+// the command, giving up after groupExitTimeout.
+func (c *runCmd) waitProcessGroup() {
+	deadline := time.Now().Add(groupExitTimeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(-c.Process.Pid, 0); err == syscall.ESRCH {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
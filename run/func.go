@@ -0,0 +1,133 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Func is a Go function that can be run as a task.
+//
+// The function receives the environment variables provided to the task and
+// must stop when the context is done. Output written to stdout and stderr
+// is handled as the output of any other runner.
+//
+// Functions can't be killed: if a function keeps running after its context
+// is done and it is sent os.Kill, the runner stops waiting for it and the
+// goroutine running it is left behind.
+type Func func(ctx context.Context, env []string, stdout, stderr io.Writer) error
+
+// funcRunner runs a Go function in its own goroutine.
+type funcRunner struct {
+	fn     Func
+	env    []string
+	stdout *io.PipeWriter
+	stderr *io.PipeWriter
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	killed   chan struct{}
+	killOnce sync.Once
+}
+
+// NewFuncRunner creates a runner for a Go function.
+func NewFuncRunner(fn Func, env []string) (Runner, error) {
+	if fn == nil {
+		return nil, errors.New("No function specified to run")
+	}
+	return &funcRunner{fn: fn, env: env, killed: make(chan struct{})}, nil
+}
+
+// StdoutPipe returns a pipe connected to the stdout writer of the function.
+func (r *funcRunner) StdoutPipe() (io.ReadCloser, error) {
+	if r.stdout != nil {
+		return nil, errors.New("Stdout already set")
+	}
+	pr, pw := io.Pipe()
+	r.stdout = pw
+	return pr, nil
+}
+
+// StderrPipe returns a pipe connected to the stderr writer of the function.
+func (r *funcRunner) StderrPipe() (io.ReadCloser, error) {
+	if r.stderr != nil {
+		return nil, errors.New("Stderr already set")
+	}
+	pr, pw := io.Pipe()
+	r.stderr = pw
+	return pr, nil
+}
+
+// Start calls the function in a new goroutine.
+func (r *funcRunner) Start() error {
+	if r.done != nil {
+		return errors.New("Runner already started")
+	}
+
+	var stdout, stderr io.Writer = io.Discard, io.Discard
+	if r.stdout != nil {
+		stdout = r.stdout
+	}
+	if r.stderr != nil {
+		stderr = r.stderr
+	}
+
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		defer r.closePipes()
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = fmt.Errorf("Function panicked: %v", p)
+			}
+		}()
+		r.err = r.fn(ctx, r.env, stdout, stderr)
+	}()
+	return nil
+}
+
+// Wait waits for the function to return and returns its error, or until
+// the runner is killed.
+func (r *funcRunner) Wait() error {
+	if r.done == nil {
+		return errors.New("Runner not started")
+	}
+	select {
+	case <-r.done:
+		r.cancel()
+		return r.err
+	case <-r.killed:
+		return errors.New("Function killed before returning")
+	}
+}
+
+// Signal cancels the context of the function, no matter which signal is
+// sent, as functions can't receive signals. Killing the runner also makes
+// Wait return without waiting for the function.
+func (r *funcRunner) Signal(sig os.Signal) error {
+	if r.done == nil {
+		return errors.New("Runner not started")
+	}
+	r.cancel()
+	if sig == os.Kill {
+		r.killOnce.Do(func() { close(r.killed) })
+	}
+	return nil
+}
+
+// closePipes makes readers of the outputs get EOF once the function returns.
+func (r *funcRunner) closePipes() {
+	if r.stdout != nil {
+		r.stdout.Close()
+	}
+	if r.stderr != nil {
+		r.stderr.Close()
+	}
+}
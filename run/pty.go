@@ -0,0 +1,27 @@
+package run
+
+// TerminalRunner is implemented by runners that can run code in a
+// pseudo-terminal, so programs that check whether their output is a
+// terminal keep their colours and interactive features.
+type TerminalRunner interface {
+	Runner
+
+	// UseTerminal makes the runner use a pseudo-terminal for its
+	// standard input and outputs. It must be called before StdoutPipe
+	// and StderrPipe. Standard output and standard error are merged,
+	// and all the output is read from StdoutPipe.
+	UseTerminal() error
+}
+
+// ForegroundRunner is implemented by runners that move their processes to
+// the foreground of the terminal dog runs in, so interactive programs can
+// read from it.
+type ForegroundRunner interface {
+	Runner
+
+	// KeepBackground keeps the runner in the background of the terminal,
+	// so the signals sent from the terminal reach dog instead. The runner
+	// gets no standard input when it is a terminal. It must be called
+	// before Start.
+	KeepBackground()
+}
@@ -0,0 +1,190 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package run
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// terminalIO holds the state of a command running in a pseudo-terminal.
+type terminalIO struct {
+	pty *os.File
+	tty *os.File
+
+	// resize receives SIGWINCH while the command runs
+	resize chan os.Signal
+
+	// state is the original state of the terminal of dog while its
+	// input is forwarded to the pseudo-terminal
+	state     *term.State
+	stopInput chan struct{}
+	inputDone chan struct{}
+
+	// piped is set when the output is read from StdoutPipe, which
+	// closes the pseudo-terminal instead of Wait
+	piped bool
+}
+
+// UseTerminal allocates a pseudo-terminal for the command.
+func (c *runCmd) UseTerminal() error {
+	if c.Process != nil {
+		return errors.New("Runner already started")
+	}
+	if c.term != nil {
+		return nil
+	}
+	p, tty, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	c.term = &terminalIO{pty: p, tty: tty}
+
+	c.Stdin, c.Stdout, c.Stderr = tty, tty, tty
+
+	// the command starts a new session that has the pseudo-terminal as
+	// its controlling terminal, its process group id is still its pid
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	return nil
+}
+
+// startTerminal starts a command that uses a pseudo-terminal, forwarding
+// the input and window size changes of the terminal of dog to it.
+func (c *runCmd) startTerminal() error {
+	t := c.term
+
+	if size := sizeSource(); size != nil {
+		_ = pty.InheritSize(size, t.pty)
+		t.resize = make(chan os.Signal, 1)
+		signal.Notify(t.resize, unix.SIGWINCH)
+		go func() {
+			for range t.resize {
+				_ = pty.InheritSize(size, t.pty)
+			}
+		}()
+	}
+
+	if fd, ok := acquireTerminal(); ok {
+		c.terminal = fd
+		if state, err := term.MakeRaw(fd); err == nil {
+			t.state = state
+			t.forwardInput(fd)
+		}
+	}
+
+	err := c.Cmd.Start()
+
+	// the command has its own copy of the tty, closing it here makes
+	// reads from the pty end once the command exits
+	t.tty.Close()
+	if err != nil {
+		c.closeTerminal()
+		return err
+	}
+	return nil
+}
+
+// closeTerminal stops forwarding input and window size changes to the
+// pseudo-terminal, and restores the terminal of dog.
+func (c *runCmd) closeTerminal() {
+	t := c.term
+	if t.resize != nil {
+		signal.Stop(t.resize)
+		close(t.resize)
+		t.resize = nil
+	}
+	if t.stopInput != nil {
+		close(t.stopInput)
+		<-t.inputDone
+		t.stopInput = nil
+	}
+	if t.state != nil {
+		_ = term.Restore(c.terminal, t.state)
+		t.state = nil
+	}
+	c.releaseTerminal()
+	if !t.piped {
+		t.pty.Close()
+	}
+}
+
+// forwardInput copies the input of the terminal of dog to the
+// pseudo-terminal until closeTerminal is called.
+//
+// The terminal is polled instead of blocking on reads, so that no input
+// is consumed once the command has finished.
+func (t *terminalIO) forwardInput(fd int) {
+	t.stopInput = make(chan struct{})
+	t.inputDone = make(chan struct{})
+	go func() {
+		defer close(t.inputDone)
+		buf := make([]byte, 1024)
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		for {
+			select {
+			case <-t.stopInput:
+				return
+			default:
+			}
+			n, err := unix.Poll(fds, 100)
+			if err != nil && err != unix.EINTR {
+				return
+			}
+			if n <= 0 {
+				continue
+			}
+			n, err = unix.Read(fd, buf)
+			if err != nil || n == 0 {
+				return
+			}
+			if _, err = t.pty.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// sizeSource returns the terminal of dog used to set the window size of
+// pseudo-terminals, or nil if dog isn't running in a terminal.
+func sizeSource() *os.File {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if term.IsTerminal(int(f.Fd())) {
+			return f
+		}
+	}
+	return nil
+}
+
+// reader returns the reader of the output of the command. The
+// pseudo-terminal is closed with it instead of by Wait.
+func (t *terminalIO) reader() io.ReadCloser {
+	t.piped = true
+	return ptyReader{pty: t.pty}
+}
+
+// ptyReader reads the output of a command from a pseudo-terminal.
+type ptyReader struct {
+	pty *os.File
+}
+
+// Read reads from the pseudo-terminal, reporting EOF instead of the error
+// returned once the command has exited and the tty is closed.
+func (r ptyReader) Read(p []byte) (int, error) {
+	n, err := r.pty.Read(p)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the pseudo-terminal.
+func (r ptyReader) Close() error {
+	return r.pty.Close()
+}
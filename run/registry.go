@@ -0,0 +1,65 @@
+package run
+
+import (
+	"sort"
+	"sync"
+)
+
+// RunnerFactory creates a runner for the given code, working directory,
+// environment variables and positional arguments.
+type RunnerFactory func(code string, workdir string, env []string, args ...string) (Runner, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]RunnerFactory)
+)
+
+func init() {
+	Register("auto", NewAutoRunner)
+	Register("sh", NewShRunner)
+	Register("bash", NewBashRunner)
+	Register("python", NewPythonRunner)
+	Register("ruby", NewRubyRunner)
+	Register("node", NewNodeRunner)
+	Register("perl", NewPerlRunner)
+}
+
+// Register makes a runner available by name, so tasks can use it in their
+// runner directive. Registering a name twice replaces the previous factory.
+//
+// Register panics if factory is nil.
+func Register(name string, factory RunnerFactory) {
+	if factory == nil {
+		panic("run: Register factory is nil")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Unregister removes the runner registered with the given name, if any.
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+// Lookup returns the factory registered with the given name.
+func Lookup(name string) (RunnerFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// Runners returns the sorted names of all registered runners.
+func Runners() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
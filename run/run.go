@@ -3,12 +3,16 @@ package run
 import (
 	"bufio"
 	"io"
+	"os"
 )
 
 // Runner just runs anything.
 type Runner interface {
 	// StdoutPipe returns a pipe that will be connected to the runner's
 	// standard output when the command starts.
+	//
+	// Output can still be read from the pipes after Wait returns, and
+	// the caller must close them.
 	StdoutPipe() (io.ReadCloser, error)
 
 	// StderrPipe returns a pipe that will be connected to the runner's
@@ -23,27 +27,97 @@ type Runner interface {
 	// The returned error is nil if the runner has no problems copying
 	// stdin, stdout, and stderr, and exits with a zero exit status.
 	Wait() error
+
+	// Signal sends a signal to the runner and to every process started
+	// by it. It must have been started by Start.
+	Signal(sig os.Signal) error
 }
 
 // NewShRunner creates a system standard shell script runner.
-func NewShRunner(code string, workdir string, env []string) (Runner, error) {
+//
+// Optional arguments are passed to the script as positional parameters.
+func NewShRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
 	return newCmdRunner(runCmdProperties{
-		runner:        "sh",
+		command:       []string{"sh"},
 		fileExtension: ".sh",
 		code:          code,
 		workdir:       workdir,
 		env:           env,
+		args:          args,
 	})
 }
 
 // NewBashRunner creates a Bash runner.
-func NewBashRunner(code string, workdir string, env []string) (Runner, error) {
+func NewBashRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
 	return newCmdRunner(runCmdProperties{
-		runner:        "bash",
+		command:       []string{"bash"},
 		fileExtension: ".sh",
 		code:          code,
 		workdir:       workdir,
 		env:           env,
+		args:          args,
+	})
+}
+
+// NewPythonRunner creates a Python 3 runner.
+func NewPythonRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
+	return newCmdRunner(runCmdProperties{
+		command:       []string{"python3"},
+		fileExtension: ".py",
+		code:          code,
+		workdir:       workdir,
+		env:           env,
+		args:          args,
+	})
+}
+
+// NewRubyRunner creates a Ruby runner.
+func NewRubyRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
+	return newCmdRunner(runCmdProperties{
+		command:       []string{"ruby"},
+		fileExtension: ".rb",
+		code:          code,
+		workdir:       workdir,
+		env:           env,
+		args:          args,
+	})
+}
+
+// NewNodeRunner creates a Node.js runner.
+func NewNodeRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
+	return newCmdRunner(runCmdProperties{
+		command:       []string{"node"},
+		fileExtension: ".js",
+		code:          code,
+		workdir:       workdir,
+		env:           env,
+		args:          args,
+	})
+}
+
+// NewPerlRunner creates a Perl runner.
+func NewPerlRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
+	return newCmdRunner(runCmdProperties{
+		command:       []string{"perl"},
+		fileExtension: ".pl",
+		code:          code,
+		workdir:       workdir,
+		env:           env,
+		args:          args,
+	})
+}
+
+// NewCommandRunner creates a runner that writes the code to a temporary file
+// with the given extension and runs the command with the path of that file
+// appended to its arguments, as in `deno run -A <file>.ts`.
+func NewCommandRunner(command []string, fileExtension string, code string, workdir string, env []string, args ...string) (Runner, error) {
+	return newCmdRunner(runCmdProperties{
+		command:       command,
+		fileExtension: fileExtension,
+		code:          code,
+		workdir:       workdir,
+		env:           env,
+		args:          args,
 	})
 }
 
@@ -1,3 +1,4 @@
+//go:build integration
 // +build integration
 
 package run
@@ -6,6 +7,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -40,17 +44,130 @@ func TestBashRunner(t *testing.T) {
 	}
 }
 
+func TestShRunnerArgs(t *testing.T) {
+	runner, err := NewShRunner(`echo "$1 $2"`, ".", nil, "Hello", "args")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	outputString, err := getOutputString(runner)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	want := "Hello args"
+	if got := outputString; got != want {
+		t.Errorf("Expected '%v' but was '%v'", want, got)
+	}
+}
+
+func TestInterpreterRunners(t *testing.T) {
+	for _, test := range []struct {
+		interpreter string
+		newRunner   func(string, string, []string, ...string) (Runner, error)
+		code        string
+	}{
+		{"python3", NewPythonRunner, `import os, sys; print("Hello " + os.environ["RUNNER"] + " " + sys.argv[1])`},
+		{"ruby", NewRubyRunner, `puts "Hello #{ENV['RUNNER']} #{ARGV[0]}"`},
+		{"node", NewNodeRunner, `console.log("Hello " + process.env.RUNNER + " " + process.argv[2])`},
+		{"perl", NewPerlRunner, `print "Hello $ENV{RUNNER} $ARGV[0]\n"`},
+	} {
+		if _, err := exec.LookPath(test.interpreter); err != nil {
+			t.Logf("Skipping %s runner, interpreter not found", test.interpreter)
+			continue
+		}
+		runner, err := test.newRunner(test.code, ".", []string{"RUNNER=" + test.interpreter}, "args")
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+		outputString, err := getOutputString(runner)
+		if err != nil {
+			t.Errorf("%s: %v", test.interpreter, err)
+		}
+		want := "Hello " + test.interpreter + " args"
+		if got := outputString; got != want {
+			t.Errorf("Expected '%v' but was '%v'", want, got)
+		}
+	}
+}
+
+func TestAutoRunner(t *testing.T) {
+	for _, test := range []struct {
+		code string
+		want string
+	}{
+		{"#!/usr/bin/env bash\necho \"Hello ${BASH_VERSION:+bash} $1\"", "Hello bash args"},
+		{"#!/bin/sh -e\necho \"Hello $0\" | grep -c task", "1"},
+		{`echo "Hello sh $1"`, "Hello sh args"},
+	} {
+		runner, err := NewAutoRunner(test.code, ".", nil, "args")
+		if err != nil {
+			t.Errorf(err.Error())
+			continue
+		}
+		outputString, err := getOutputString(runner)
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+		if got := outputString; got != test.want {
+			t.Errorf("Expected '%v' but was '%v'", test.want, got)
+		}
+	}
+}
+
+func TestHasShebang(t *testing.T) {
+	for _, test := range []struct {
+		code string
+		want bool
+	}{
+		{"#!/bin/bash\necho", true},
+		{"#!/bin/sh -e\r\necho", true},
+		{"#! /usr/bin/env python3\nprint()", true},
+		{"#!\necho", false},
+		{"echo '#!/bin/bash'", false},
+	} {
+		if got := HasShebang(test.code); got != test.want {
+			t.Errorf("Expected %t but was %t for %q", test.want, got, test.code)
+		}
+	}
+}
+
+func TestAutoRunnerTaskPath(t *testing.T) {
+	dir := t.TempDir()
+	interpreter := "#!/bin/sh\necho \"interpreted $1\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "dog-interpreter"), []byte(interpreter), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	env := []string{"PATH=" + dir + string(os.PathListSeparator) + os.Getenv("PATH")}
+	runner, err := NewAutoRunner("#!/usr/bin/env dog-interpreter\n", ".", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := getOutputString(runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "interpreted ") || !strings.HasSuffix(got, "/task") {
+		t.Errorf("Expected the interpreter in the task PATH to run the code but the output was %q", got)
+	}
+}
+
 func getOutputString(runner Runner) (outputString string, err error) {
 	output := new(bytes.Buffer)
 	runOut, runErr, err := GetOutputs(runner)
 	if err != nil {
 		return outputString, fmt.Errorf("%s: %s", err, runErr)
 	}
-	go io.Copy(output, runOut)
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(output, runOut)
+		close(copied)
+	}()
 	err = runner.Start()
 	if err != nil {
 		return
 	}
+	<-copied
 	err = runner.Wait()
 	if err != nil {
 		return
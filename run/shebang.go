@@ -0,0 +1,34 @@
+package run
+
+import (
+	"strings"
+)
+
+// NewAutoRunner creates a runner that uses the interpreter defined in the
+// shebang line of the code (as in `#!/usr/bin/env python3`). The code is
+// written to an executable file that is run directly, so the system reads
+// the shebang line and interpreters referenced through env are looked up
+// in the environment of the task. The code is run by the system standard
+// shell if it has no shebang line.
+func NewAutoRunner(code string, workdir string, env []string, args ...string) (Runner, error) {
+	if !HasShebang(code) {
+		return NewShRunner(code, workdir, env, args...)
+	}
+	return newCmdRunner(runCmdProperties{
+		executable: true,
+		code:       code,
+		workdir:    workdir,
+		env:        env,
+		args:       args,
+	})
+}
+
+// HasShebang reports whether the code starts with a shebang line naming an
+// interpreter.
+func HasShebang(code string) bool {
+	if !strings.HasPrefix(code, "#!") {
+		return false
+	}
+	line := strings.SplitN(code[2:], "\n", 2)[0]
+	return strings.TrimSpace(line) != ""
+}
@@ -0,0 +1,28 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package run
+
+import (
+	"errors"
+	"io"
+)
+
+// terminalIO is not used on platforms without pseudo-terminals.
+type terminalIO struct{}
+
+// UseTerminal always fails, as pseudo-terminals are not supported on
+// this platform.
+func (c *runCmd) UseTerminal() error {
+	return errors.New("Pseudo-terminals are not supported on this platform")
+}
+
+func (c *runCmd) startTerminal() error { return nil }
+
+func (c *runCmd) closeTerminal() {}
+
+func (t *terminalIO) reader() io.ReadCloser { return nil }
+
+// takeForeground does nothing, the command shares the terminal with dog.
+func (c *runCmd) takeForeground() {}
+
+func (c *runCmd) releaseTerminal() {}
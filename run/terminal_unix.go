@@ -0,0 +1,80 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package run
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// terminalOwner makes sure that only one runner at a time uses the
+// terminal when several of them run concurrently.
+var terminalOwner sync.Mutex
+
+// acquireTerminal returns the file descriptor of the terminal attached to
+// the standard input if the process group of dog is in its foreground and
+// no other runner is using it.
+//
+// The terminal must be given back with releaseTerminal.
+func acquireTerminal() (int, bool) {
+	if !terminalOwner.TryLock() {
+		return 0, false
+	}
+	fd := int(os.Stdin.Fd())
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || pgrp != unix.Getpgrp() {
+		terminalOwner.Unlock()
+		return 0, false
+	}
+	return fd, true
+}
+
+// releaseTerminal puts the process group of dog back in the foreground of
+// the terminal after a runner has finished using it.
+func releaseTerminal(fd int) {
+	defer terminalOwner.Unlock()
+
+	// changing the foreground process group from a background process
+	// raises SIGTTOU unless it is ignored
+	signal.Ignore(unix.SIGTTOU)
+	defer signal.Reset(unix.SIGTTOU)
+
+	_ = unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, unix.Getpgrp())
+}
+
+// stdinIsTerminal reports whether the standard input is a terminal.
+func stdinIsTerminal() bool {
+	_, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil
+}
+
+// takeForeground moves the process group of the command to the foreground
+// of the terminal so interactive commands can read from it, as commands in
+// a process group of their own run in the background. If another runner is
+// already using the terminal, or the command is kept in the background, it
+// gets no standard input instead, as reading from the terminal in the
+// background would stop it.
+func (c *runCmd) takeForeground() {
+	if !c.background {
+		if fd, ok := acquireTerminal(); ok {
+			c.SysProcAttr.Foreground = true
+			c.SysProcAttr.Ctty = fd
+			c.terminal = fd
+			return
+		}
+	}
+	if stdinIsTerminal() {
+		c.Stdin = nil
+	}
+}
+
+// releaseTerminal gives back the terminal if the command was using it.
+func (c *runCmd) releaseTerminal() {
+	if c.terminal >= 0 {
+		releaseTerminal(c.terminal)
+		c.terminal = -1
+	}
+}
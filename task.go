@@ -1,5 +1,20 @@
 package dog
 
+import (
+	"time"
+
+	"github.com/dogtools/dog/run"
+)
+
+// TaskFunc is a Go function that runs as a task. The environment includes
+// the env, registers and params of the task, but not the variables coming
+// from the system, which are available through the os package.
+//
+// Functions must return once their context is done. A function that
+// ignores it is abandoned after KillGracePeriod and keeps running in the
+// background.
+type TaskFunc = run.Func
+
 // Task represents a task described in the Dogfile format.
 type Task struct {
 	// Name of the task.
@@ -11,24 +26,54 @@ type Task struct {
 	// The code that will be executed.
 	Code string
 
+	// Func is a Go function executed instead of Code. The runner and the
+	// working directory of the task are ignored when it is set.
+	Func TaskFunc
+
 	// Defaults to operating system main shell.
 	Runner string
 
+	// RunnerSpec describes the runner when it is defined in the Dogfile
+	// instead of being one of the registered runners.
+	RunnerSpec *RunnerSpec
+
 	// Pre-hooks execute other tasks before starting the current one.
 	Pre []string
 
+	// Parallel means that pre-hooks don't depend on each other, so they
+	// can run at the same time.
+	Parallel bool
+
 	// Post-hooks are analog to pre-hooks but they are executed after
 	// current task finishes its execution.
 	Post []string
 
+	// Always means that the task runs every time it is referenced in a
+	// task chain, instead of only once.
+	Always bool
+
 	// Default values for environment variables can be provided in the Dogfile.
 	// They can be modified at execution time.
+	//
+	// Tasks parsed from a Dogfile start with the global variables and the
+	// global default values of the Dogfile, followed by their own values.
+	// When a variable appears more than once the last value is used.
+	//
+	// Values can be Go templates, which are executed when the task runs.
 	Env []string
 
+	// Vars holds the global variables of the Dogfile defining the task.
+	Vars map[string]string
+
 	// Sets the working directory for the task. Relative paths are
 	// considered relative to the location of the Dogfile.
 	Workdir string
 
+	// workdirParams holds the template of a workdir that uses the task
+	// parameters, which is executed when the task runs. Relative paths
+	// are relative to Workdir.
+	workdirParams string
+
 	// Register stores the output of the task so it can be accessed by
 	// other tasks in the task chain.
 	//
@@ -36,4 +81,53 @@ type Task struct {
 	// task chain runners using the register name as key and the output
 	// as value.
 	Register string
+
+	// RegisterStderr stores the standard error of the task in the same
+	// way as Register does with its output.
+	RegisterStderr string
+
+	// RegisterStatus stores the exit status of the task.
+	RegisterStatus string
+
+	// RegisterFormat is the format of the output stored in Register:
+	// RegisterText, the default, or RegisterJSON.
+	RegisterFormat string
+
+	// RegisterPath selects the value stored in Register from the JSON
+	// output of the task, as in .items[0].name.
+	RegisterPath string
+
+	// RegisterTee means that the output stored in registers is also
+	// written to the output of the task chain.
+	RegisterTee bool
+
+	// IgnoreErrors means that the task chain goes on when the task exits
+	// with a non-zero exit status, as if the task had succeeded.
+	IgnoreErrors bool
+
+	// Params are the parameters accepted by the task at runtime.
+	Params []Param
+
+	// Timeout is the maximum amount of time the task is allowed to spend
+	// running. A zero value means no timeout.
+	Timeout time.Duration
+
+	// TTY runs the task in a pseudo-terminal instead of using pipes for
+	// its output, which is then written to stdout. Registers store the
+	// output without terminal escape sequences.
+	TTY bool
+}
+
+// RunnerSpec describes a runner defined in the Dogfile.
+type RunnerSpec struct {
+	// Command that runs the code. The path of a temporary file containing
+	// the code of the task is appended to its arguments.
+	Command []string
+
+	// Extension of the temporary file, as some interpreters require it.
+	Extension string
+
+	// Environment variables provided to every task using the runner.
+	// Task environment variables take precedence over them.
+	Env []string
 }